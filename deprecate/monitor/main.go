@@ -2,7 +2,7 @@ package main
 
 import (
 	tm "github.com/buger/goterm"
-	"github.com/NotHere1/monitor/utils"
+	"github.com/NotHere1/monitor/deprecate/monitor/utils"
 	"container/list"
 	"time"
 	"fmt"
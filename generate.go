@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/NotHere1/monitor/utils"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// response sizes of sample_data/apache.log
+var generateBytes = []string{"1136", "1194", "1234", "1261", "1307"}
+
+const generateUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/72.0 Safari/537.36"
+
+// client hosts fill 10.0.0.1 to 10.0.0.254, then 10.0.1.1 and on up to 10.0.255.254
+const maxGenerateHosts = 256 * 254
+
+// address of the ith client host, counted from 0
+func generateHost(i int) string {
+	return fmt.Sprintf("10.0.%d.%d", i/254, i%254+1)
+}
+
+// values picked at random in proportion to their weight
+type weighted struct {
+	values     []string
+	cumulative []int
+}
+
+// parse a key=weight list. e.g. GET=3,POST=1
+func parseWeights(spec string) (weighted, error) {
+	kvs, err := utils.ParseKeyValues(spec)
+	if err != nil {
+		return weighted{}, err
+	}
+	var w weighted
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // same seed, same output
+	total := 0
+	for _, k := range keys {
+		weight, err := strconv.Atoi(kvs[k])
+		if err != nil || weight < 0 {
+			return weighted{}, fmt.Errorf("bad weight for %s: %q", k, kvs[k])
+		}
+		total += weight
+		w.values = append(w.values, k)
+		w.cumulative = append(w.cumulative, total)
+	}
+	if total == 0 {
+		return weighted{}, fmt.Errorf("no positive weight in %q", spec)
+	}
+	return w, nil
+}
+
+func (w weighted) pick(rng *rand.Rand) string {
+	n := rng.Intn(w.cumulative[len(w.cumulative)-1])
+	return w.values[sort.SearchInts(w.cumulative, n+1)]
+}
+
+// a stretch of seconds, counted from the start of the run, whose rate is multiplied by factor
+// an outage is a factor 0 period
+type period struct {
+	offset   int
+	duration int
+	factor   float64
+}
+
+// parse offset:duration[:factor] periods, comma separated. e.g. 120:30:5,300:10:10
+func parsePeriods(spec string, withFactor bool) ([]period, error) {
+	var periods []period
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if (withFactor && len(fields) != 3) || (!withFactor && len(fields) != 2) {
+			return nil, fmt.Errorf("bad period: %q", item)
+		}
+		offset, err1 := strconv.Atoi(fields[0])
+		duration, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || offset < 0 || duration <= 0 {
+			return nil, fmt.Errorf("bad period: %q", item)
+		}
+		p := period{offset, duration, 0}
+		if withFactor {
+			factor, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil || factor < 0 {
+				return nil, fmt.Errorf("bad period: %q", item)
+			}
+			p.factor = factor
+		}
+		periods = append(periods, p)
+	}
+	return periods, nil
+}
+
+// rate multiplier at a second of the run. outages win over spikes
+func rateFactor(periods []period, second int) float64 {
+	factor := 1.0
+	for _, p := range periods {
+		if second >= p.offset && second < p.offset+p.duration {
+			if p.factor == 0 {
+				return 0
+			}
+			factor *= p.factor
+		}
+	}
+	return factor
+}
+
+// write one line in csv (sample_data/apache.log), clf or combined format
+func writeLogLine(w io.Writer, format string, host string, epoch int64, request string, status string, bytes string) {
+	switch format {
+	case "csv":
+		fmt.Fprintf(w, "%q,\"-\",\"apache\",%d,%q,%s,%s\n", host, epoch, request, status, bytes)
+	case "clf":
+		fmt.Fprintf(w, "%s - apache [%s] %q %s %s\n", host, time.Unix(epoch, 0).UTC().Format("02/Jan/2006:15:04:05 -0700"), request, status, bytes)
+	case "combined":
+		fmt.Fprintf(w, "%s - apache [%s] %q %s %s \"-\" %q\n", host, time.Unix(epoch, 0).UTC().Format("02/Jan/2006:15:04:05 -0700"), request, status, bytes, generateUserAgent)
+	}
+}
+
+// generate writes synthetic access log lines for load tests and alert demos
+func runGenerate(args []string) {
+
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	var format string
+	var outputFilePath string
+	var rate float64
+	var duration int
+	var start int64
+	var hostCount int
+	var sectionWeights string
+	var methodWeights string
+	var statusWeights string
+	var spikes string
+	var outages string
+	var realtime bool
+	var seed int64
+	fs.StringVar(&format, "format", "csv", "Output log format. csv (like sample_data/apache.log), clf or combined")
+	fs.StringVar(&outputFilePath, "o", "", "Output file path. Default stdout")
+	fs.Float64Var(&rate, "rate", 10, "Base rate in lines per second")
+	fs.IntVar(&duration, "duration", 600, "Seconds of traffic to generate. 0 with -realtime runs until interrupted")
+	fs.Int64Var(&start, "start", 0, "Epoch of the first second. Default now. Ignored with -realtime")
+	fs.IntVar(&hostCount, "hosts", 5, "Number of client hosts, 10.0.0.1 and up. At most 65024")
+	fs.StringVar(&sectionWeights, "sections", "/api/user=5,/api/help=2,/report=2", "Request path weights")
+	fs.StringVar(&methodWeights, "methods", "GET=3,POST=1", "Request method weights")
+	fs.StringVar(&statusWeights, "statuses", "200=95,404=2,500=3", "Response status weights")
+	fs.StringVar(&spikes, "spikes", "", "Traffic spikes as offset:duration:factor seconds into the run, comma separated. e.g. 120:30:5")
+	fs.StringVar(&outages, "outages", "", "Outages without any traffic as offset:duration, comma separated. e.g. 300:60")
+	fs.BoolVar(&realtime, "realtime", false, "Write each second's lines as that second passes, stamped with the current time. Default dump all at once")
+	fs.Int64Var(&seed, "seed", 0, "Random seed. Default from the clock")
+	fs.Parse(args)
+
+	if format != "csv" && format != "clf" && format != "combined" {
+		utils.PrintMsgExit("error: -format must be csv, clf or combined")
+	}
+	if rate < 0 || hostCount <= 0 || duration < 0 || (duration == 0 && !realtime) {
+		utils.PrintMsgExit("error: -rate, -hosts and -duration must be positive")
+	}
+	if hostCount > maxGenerateHosts {
+		utils.PrintMsgExit(fmt.Sprintf("error: -hosts must be at most %d", maxGenerateHosts))
+	}
+	sections, err := parseWeights(sectionWeights)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: sections: %s", err))
+	}
+	methods, err := parseWeights(methodWeights)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: methods: %s", err))
+	}
+	statuses, err := parseWeights(statusWeights)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: statuses: %s", err))
+	}
+	periods, err := parsePeriods(spikes, true)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: spikes: %s", err))
+	}
+	outagePeriods, err := parsePeriods(outages, false)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: outages: %s", err))
+	}
+	periods = append(periods, outagePeriods...)
+
+	var out io.Writer = os.Stdout
+	if outputFilePath != "" {
+		f, err := os.Create(outputFilePath)
+		utils.Check(err)
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	if start == 0 || realtime {
+		start = time.Now().Unix()
+	}
+
+	if format == "csv" {
+		fmt.Fprintln(w, `"remotehost","rfc931","authuser","date","request","status","bytes"`)
+	}
+	for second := 0; duration == 0 || second < duration; second++ {
+		epoch := start + int64(second)
+		if realtime {
+			time.Sleep(time.Until(time.Unix(epoch, 0)))
+		}
+
+		// about rate lines a second, give or take a fifth
+		expected := rate * rateFactor(periods, second) * (0.8 + 0.4*rng.Float64())
+		lines := int(expected)
+		if rng.Float64() < expected-float64(lines) {
+			lines++
+		}
+		for i := 0; i < lines; i++ {
+			host := generateHost(rng.Intn(hostCount))
+			request := fmt.Sprintf("%s %s HTTP/1.0", methods.pick(rng), sections.pick(rng))
+			writeLogLine(w, format, host, epoch, request, statuses.pick(rng), generateBytes[rng.Intn(len(generateBytes))])
+		}
+		if realtime {
+			utils.Check(w.Flush())
+		}
+	}
+}
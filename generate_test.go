@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseWeights(t *testing.T) {
+	w, err := parseWeights("POST=1,GET=3,PUT=0")
+	if err != nil {
+		t.Fatalf("parseWeights failed. Got: %s", err)
+	}
+	// sorted by value, so the same seed gives the same output
+	if !reflect.DeepEqual(w.values, []string{"GET", "POST", "PUT"}) || !sameInts(w.cumulative, []int{3, 4, 4}) {
+		t.Errorf("parseWeights failed. Want: GET POST PUT at 3 4 4. Got: %v at %v", w.values, w.cumulative)
+	}
+	counts := make(map[string]int)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 4000; i++ {
+		counts[w.pick(rng)] += 1
+	}
+	if counts["PUT"] != 0 || counts["GET"] < 2700 || counts["GET"] > 3300 {
+		t.Errorf("pick failed. Want: about 3000 GET, 1000 POST and no PUT. Got: %v", counts)
+	}
+
+	for _, spec := range []string{"GET=x", "GET=-1", "GET=0,POST=0", "GET"} {
+		if _, err := parseWeights(spec); err == nil {
+			t.Errorf("parseWeights failed. Want: an error for %q", spec)
+		}
+	}
+}
+
+func TestParsePeriods(t *testing.T) {
+	cases := []struct {
+		spec       string
+		withFactor bool
+		want       []period
+		err        bool
+	}{
+		{"", true, nil, false},
+		{"120:30:5, 300:10:0.5", true, []period{{120, 30, 5}, {300, 10, 0.5}}, false},
+		{"300:60", false, []period{{300, 60, 0}}, false},
+		{"120:30", true, nil, true},
+		{"300:60:2", false, nil, true},
+		{"120:0:5", true, nil, true},
+		{"-1:30:5", true, nil, true},
+		{"120:30:-5", true, nil, true},
+		{"a:30", false, nil, true},
+	}
+	for _, c := range cases {
+		got, err := parsePeriods(c.spec, c.withFactor)
+		if (err != nil) != c.err || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parsePeriods failed. %q Want: %v, error %v. Got: %v, %v", c.spec, c.want, c.err, got, err)
+		}
+	}
+}
+
+func TestRateFactor(t *testing.T) {
+	periods := []period{{10, 10, 5}, {15, 10, 2}, {18, 4, 0}}
+	cases := []struct {
+		second int
+		want   float64
+	}{
+		{0, 1},
+		{10, 5},
+		{15, 10}, // overlapping spikes multiply
+		{18, 0},  // an outage wins
+		{21, 0},
+		{22, 2},
+		{25, 1},
+	}
+	for _, c := range cases {
+		if got := rateFactor(periods, c.second); got != c.want {
+			t.Errorf("rateFactor failed. At %d Want: %v. Got: %v", c.second, c.want, got)
+		}
+	}
+}
+
+func TestGenerateHost(t *testing.T) {
+	cases := []struct {
+		i    int
+		want string
+	}{
+		{0, "10.0.0.1"},
+		{253, "10.0.0.254"},
+		{254, "10.0.1.1"},
+		{maxGenerateHosts - 1, "10.0.255.254"},
+	}
+	for _, c := range cases {
+		if got := generateHost(c.i); got != c.want {
+			t.Errorf("generateHost failed. %d Want: %s. Got: %s", c.i, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/NotHere1/monitor
+
+go 1.22
+
+require (
+	github.com/buger/goterm v1.0.4
+	github.com/klauspost/compress v1.18.0
+)
+
+require golang.org/x/sys v0.0.0-20210331175145-43e1dd70ce54 // indirect
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/NotHere1/monitor/utils"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// one input stream. stdin or a -file path
+type logSource struct {
+	name    string
+	scanner *bufio.Scanner
+	sniffed []string // lines read ahead by -format auto. parsed before the rest
+}
+
+// a line read from a source. err is the reason it was rejected
+type parsedLine struct {
+	raw   string
+	log   CommonLog
+	err   error
+	epoch int
+}
+
+// follow tails the file across rotation. otherwise archives are decompressed on the fly
+func openSource(path string, follow bool) (*logSource, io.Closer, error) {
+	var r io.ReadCloser
+	var err error
+	if follow {
+		if utils.IsCompressedPath(path) {
+			return nil, nil, fmt.Errorf("-follow cannot read compressed files: %s", path)
+		}
+		r, err = utils.NewFollowReader(path, 250*time.Millisecond)
+	} else {
+		r, err = utils.OpenDecompressed(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &logSource{path, bufio.NewScanner(r), nil}, r, nil
+}
+
+// read ahead up to n lines for format detection
+func (src *logSource) sniff(n int) []string {
+	for len(src.sniffed) < n && src.scanner.Scan() {
+		src.sniffed = append(src.sniffed, src.scanner.Text())
+	}
+	return src.sniffed
+}
+
+// parse every line of the source in order, tagged with the source name
+// each source needs a parser of its own, csv keeps the header it has seen
+func (src *logSource) parse(parseLine lineParser, out chan<- parsedLine) {
+	emit := func(line string) {
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+		cl, err := parseLine(line)
+		cl.source = src.name
+		epoch, _ := strconv.Atoi(cl.epoch)
+		out <- parsedLine{line, cl, err, epoch}
+	}
+	for _, line := range src.sniffed {
+		emit(line)
+	}
+	for src.scanner.Scan() {
+		emit(src.scanner.Text())
+	}
+	if err := src.scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", src.name, err)
+		os.Exit(1)
+	}
+	close(out)
+}
+
+// merge streams that are each in epoch order into one, oldest first
+// rejected lines have no usable epoch and are handled as soon as they are read
+func mergeByEpoch(streams []chan parsedLine, handle func(parsedLine)) {
+	heads := make([]*parsedLine, len(streams))
+	for {
+		oldest := -1
+		for i := range streams {
+			for heads[i] == nil && streams[i] != nil {
+				pl, ok := <-streams[i]
+				if !ok {
+					streams[i] = nil
+				} else if pl.err != nil {
+					handle(pl)
+				} else {
+					heads[i] = &pl
+				}
+			}
+			if heads[i] != nil && (oldest < 0 || heads[i].epoch < heads[oldest].epoch) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			return
+		}
+		handle(*heads[oldest])
+		heads[oldest] = nil
+	}
+}
+
+// hold each line back until its epoch, counted from the first line, is due
+// speed 10 plays 10 seconds of the log each second
+// rejected lines have no usable epoch and are handled as soon as they are read
+func pace(handle func(parsedLine), speed float64) func(parsedLine) {
+	first := -1
+	var start time.Time
+	return func(pl parsedLine) {
+		if pl.err == nil {
+			if first < 0 {
+				first, start = pl.epoch, time.Now()
+			}
+			due := start.Add(time.Duration(float64(pl.epoch-first) / speed * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		handle(pl)
+	}
+}
+
+// handle lines in arrival order. used with -follow, where a quiet file would stall mergeByEpoch
+func fanIn(streams []chan parsedLine, handle func(parsedLine)) {
+	merged := make(chan parsedLine)
+	var wg sync.WaitGroup
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream chan parsedLine) {
+			for pl := range stream {
+				merged <- pl
+			}
+			wg.Done()
+		}(stream)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	for pl := range merged {
+		handle(pl)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPace(t *testing.T) {
+	start := time.Now()
+	var handled []time.Duration
+	handle := pace(func(pl parsedLine) {
+		handled = append(handled, time.Since(start))
+	}, 100)
+
+	// 100x. one second of the log is 10ms
+	handle(parsedLine{epoch: 1000})
+	handle(parsedLine{epoch: 1003})
+	handle(parsedLine{epoch: 1001})                  // out of order. already due
+	handle(parsedLine{err: errors.New("bad epoch")}) // rejected. not held back
+	handle(parsedLine{epoch: 1005})
+
+	want := []time.Duration{0, 30 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond, 50 * time.Millisecond}
+	for i, w := range want {
+		if handled[i] < w || handled[i] > w+40*time.Millisecond {
+			t.Errorf("pace failed. Want: line %d at %s. Got: %s", i, w, handled[i])
+		}
+	}
+}
+
+// a gap in a replayed log lasts longer than the idle timeout of live input
+// without a timeout the window around the gap keeps every line
+func TestReplayGap(t *testing.T) {
+	lines := []timedLine{{1000, 60 * time.Millisecond}, {1007, 0}, {1008, 0}}
+	windows, _ := runAggregate(lines, 0, 5, 10, 10)
+
+	if len(windows) != 1 || len(windows[0].logs) != 3 {
+		t.Errorf("replay failed. Want: one window of 3 lines. Got: %v", windows)
+	}
+}
+
+func TestMergeByEpoch(t *testing.T) {
+	stream := func(lines ...parsedLine) chan parsedLine {
+		ch := make(chan parsedLine, len(lines))
+		for _, pl := range lines {
+			ch <- pl
+		}
+		close(ch)
+		return ch
+	}
+	line := func(raw string, epoch int) parsedLine {
+		return parsedLine{raw: raw, epoch: epoch}
+	}
+	streams := []chan parsedLine{
+		stream(line("a1000", 1000), line("a1003", 1003), line("a1006", 1006)),
+		stream(line("b1001", 1001), parsedLine{raw: "b bad", err: errors.New("bad epoch")}, line("b1002", 1002), line("b1007", 1007)),
+		stream(line("c1000", 1000), line("c1005", 1005)),
+	}
+
+	var got []string
+	mergeByEpoch(streams, func(pl parsedLine) {
+		got = append(got, pl.raw)
+	})
+	// oldest first, ties by input order. a rejected line as soon as it is read
+	want := []string{"a1000", "c1000", "b1001", "b bad", "b1002", "a1003", "c1005", "a1006", "b1007"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("mergeByEpoch failed. Want: %v. Got: %v", want, got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/NotHere1/monitor/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// lines the http ingest endpoint buffers before answering 429
+const ingestBufferLines = 10000
+
+// largest batch accepted in one POST
+const ingestMaxBody = 10 << 20
+
+// longest line a network input takes. longer ones are refused before they reach the scanner
+const maxNetworkLine = 64 << 10
+
+var errLineTooLong = fmt.Errorf("line longer than %d bytes", maxNetworkLine)
+
+// scanner of a network input. its buffer holds any line up to maxNetworkLine with room to spare
+func newNetworkScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 2*maxNetworkLine)
+	return scanner
+}
+
+// start the -listen inputs. e.g. syslog://:5514,http://:8080
+// each one is a logSource fed by the network instead of a file
+func listenSources(spec string) ([]*logSource, error) {
+	var sources []*logSource
+	for _, listen := range strings.Split(spec, ",") {
+		listen = strings.TrimSpace(listen)
+		scheme := strings.SplitN(listen, "://", 2)
+		if len(scheme) != 2 {
+			return nil, fmt.Errorf("bad -listen %q, want scheme://host:port", listen)
+		}
+		switch scheme[0] {
+		case "syslog":
+			src, err := listenSyslog(scheme[1])
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		case "http":
+			src, err := listenHTTP(scheme[1], ingestBufferLines)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		default:
+			return nil, fmt.Errorf("unknown -listen scheme %q", scheme[0])
+		}
+	}
+	return sources, nil
+}
+
+// accept syslog over udp and tcp on the same address
+// the access log line inside each message is written to the source, one per line
+func listenSyslog(addr string) (*logSource, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	// parallel pipe writes are serialized, so lines never interleave
+	pr, pw := io.Pipe()
+	go serveSyslogUDP(udp, pw)
+	go serveSyslogTCP(tcp, pw)
+	return &logSource{"syslog://" + addr, newNetworkScanner(pr), nil}, nil
+}
+
+// one message per datagram
+func serveSyslogUDP(conn net.PacketConn, w io.Writer) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: syslog udp:", err)
+			return
+		}
+		writeSyslogMessage(w, string(buf[:n]))
+	}
+}
+
+func serveSyslogTCP(ln net.Listener, w io.Writer) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: syslog tcp:", err)
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				msg, err := utils.ReadSyslogFrame(r)
+				if err != nil {
+					return
+				}
+				writeSyslogMessage(w, msg)
+			}
+		}(conn)
+	}
+}
+
+// messages without a syslog header are passed on as is, and rejected by the parser if malformed
+// messages over maxNetworkLine are dropped
+func writeSyslogMessage(w io.Writer, msg string) {
+	line, _ := utils.StripSyslog(strings.TrimRight(msg, "\r\n"))
+	if len(line) > maxNetworkLine {
+		fmt.Fprintln(os.Stderr, "error: syslog:", errLineTooLong)
+		return
+	}
+	line = strings.NewReplacer("\r", " ", "\n", " ").Replace(line)
+	io.WriteString(w, line+"\n")
+}
+
+// accept POSTed batches of log lines on /ingest
+// either newline delimited, or a json array of lines (objects are taken as jsonl lines)
+func listenHTTP(addr string, bufferLines int) (*logSource, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the buffer is the backpressure point. a batch that does not fit is refused with 429
+	buffer := make(chan string, bufferLines)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", ingestHandler(buffer))
+
+	go func() {
+		err := http.Serve(ln, mux)
+		fmt.Fprintln(os.Stderr, "error: http ingest:", err)
+	}()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for line := range buffer {
+			io.WriteString(pw, line+"\n")
+		}
+	}()
+	return &logSource{"http://" + addr, newNetworkScanner(pr), nil}, nil
+}
+
+// POST /ingest. the lines of an accepted batch are queued on buffer
+func ingestHandler(buffer chan string) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "POST log lines to /ingest", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, ingestMaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		lines, err := splitIngestBody(body)
+		if err == errLineTooLong {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(lines) > cap(buffer) {
+			http.Error(w, fmt.Sprintf("batch larger than %d lines", cap(buffer)), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		mu.Lock()
+		if cap(buffer)-len(buffer) < len(lines) {
+			mu.Unlock()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "ingest buffer full", http.StatusTooManyRequests)
+			return
+		}
+		for _, line := range lines {
+			buffer <- line
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "{\"accepted\": %d}\n", len(lines))
+	}
+}
+
+// a batch with a line over maxNetworkLine is refused whole with errLineTooLong
+func splitIngestBody(body []byte) ([]string, error) {
+	trimmed := bytes.TrimSpace(body)
+	if !bytes.HasPrefix(trimmed, []byte("[")) {
+		var lines []string
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if len(line) > maxNetworkLine {
+				return nil, errLineTooLong
+			}
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines, nil
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(trimmed, &batch); err != nil {
+		return nil, fmt.Errorf("bad json array: %s", err)
+	}
+	lines := make([]string, 0, len(batch))
+	for _, raw := range batch {
+		var line string
+		if err := json.Unmarshal(raw, &line); err != nil {
+			// not a string. a json access log object, kept as one jsonl line
+			var compact bytes.Buffer
+			json.Compact(&compact, raw)
+			line = compact.String()
+		}
+		if len(line) > maxNetworkLine {
+			return nil, errLineTooLong
+		}
+		lines = append(lines, strings.NewReplacer("\r", " ", "\n", " ").Replace(line))
+	}
+	return lines, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitIngestBody(t *testing.T) {
+	long := strings.Repeat("x", maxNetworkLine+1)
+	cases := []struct {
+		body string
+		want []string
+		err  bool
+	}{
+		{"a b\nc d\n", []string{"a b", "c d"}, false},
+		{"a b\r\n\r\n  \nc d", []string{"a b", "c d"}, false},
+		{`["a b", "c\nd"]`, []string{"a b", "c d"}, false},
+		{`[{"host": "h", "status": 200}]`, []string{`{"host":"h","status":200}`}, false},
+		{`[1, 2`, nil, true},
+		{"ok\n" + long, nil, true},
+		{`["` + long + `"]`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := splitIngestBody([]byte(c.body))
+		if (err != nil) != c.err || strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("splitIngestBody failed. Want: %q, error %v. Got: %q, %v", c.want, c.err, got, err)
+		}
+	}
+	if _, err := splitIngestBody([]byte(long)); err != errLineTooLong {
+		t.Errorf("splitIngestBody failed. Want: %v. Got: %v", errLineTooLong, err)
+	}
+}
+
+func TestIngestHandler(t *testing.T) {
+	buffer := make(chan string, 3)
+	handler := ingestHandler(buffer)
+	post := func(method string, body string) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, "/ingest", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	cases := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "a\nb\n", http.StatusAccepted},
+		{http.MethodPost, "c\nd\n", http.StatusTooManyRequests}, // one slot left
+		{http.MethodPost, "a\nb\nc\nd\n", http.StatusRequestEntityTooLarge},
+		{http.MethodPost, strings.Repeat("x", maxNetworkLine+1), http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "[1, 2", http.StatusBadRequest},
+		{http.MethodPost, "c\n", http.StatusAccepted},
+	}
+	for _, c := range cases {
+		if got := post(c.method, c.body); got != c.want {
+			t.Errorf("ingestHandler failed. %s %q Want: %d. Got: %d", c.method, c.body, c.want, got)
+		}
+	}
+	if len(buffer) != 3 {
+		t.Errorf("ingestHandler failed. Want: 3 buffered lines. Got: %d", len(buffer))
+	}
+}
+
+// the longest line a network input takes reaches the parser in one piece
+func TestNetworkScanner(t *testing.T) {
+	line := strings.Repeat("x", maxNetworkLine)
+	scanner := newNetworkScanner(strings.NewReader(line + "\nnext\n"))
+	var got []string
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	if scanner.Err() != nil || len(got) != 2 || got[0] != line {
+		t.Errorf("newNetworkScanner failed. Want: 2 lines. Got: %d lines, %v", len(got), scanner.Err())
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	// tm "github.com/buger/goterm"
 	"github.com/NotHere1/monitor/utils"
-	"container/list"
 	"time"
 	"fmt"
 	"os"
@@ -11,7 +10,7 @@ import (
 	"strings"
 	"flag"
 	"strconv"
-	"math"
+	"sort"
 )
 
 type CommonLog struct {
@@ -24,87 +23,219 @@ type CommonLog struct {
 	requestProtocol string
 	statusCode string
 	bytes string
+	referer string
+	userAgent string
+	extra map[string]string // LogFormat directives without a column of their own
+	source string // input the line was read from
 }
 
 type AggregateLogs struct {
 	logs []CommonLog
 	startSec int
 	endSec int
+	rejects map[string]int // malformed lines by reason
+	lateDropped int // lines behind the watermark, whose window was already flushed
+	lateCorrected int // out of order lines still put into their own window
+	leftover bool // rejects and late lines counted after the last window, sent at end of input. not a window
 }
 
-func aggregateLogs(logs <-chan CommonLog, requests chan<- AggregateLogs, results chan<- AggregateLogs, timeout time.Duration) {
+// alert event of a rule from checkRules
+// sent when it fires, and again with end set when it resolves
+type Alert struct {
+	rule alertRule
+	value float64 // of the rule metric
+	begin int
+	end int
+	endOfInput bool // resolved because the input ended, not because traffic dropped
+}
+
+func (alert Alert) String() string {
+	rule := alert.rule
+	if alert.end == 0 {
+		return fmt.Sprintf("[%s] %s generated an alert - %s = %s (threshold %s %s, window_period = %d sec), triggered at %d\n", rule.severity, rule.name, rule.metric, utils.FormatMetric(rule.metric, alert.value), rule.comparison, strconv.FormatFloat(rule.threshold, 'f', -1, 64), rule.windowSecond, alert.begin)
+	} else if alert.endOfInput {
+		return fmt.Sprintf("[%s] %s alert resolved at end of input. Begin = %d, End = %d\n", rule.severity, rule.name, alert.begin, alert.end)
+	}
+	return fmt.Sprintf("[%s] %s alert resolved. Begin = %d, End = %d\n", rule.severity, rule.name, alert.begin, alert.end)
+}
+
+func aggregateLogs(logs <-chan CommonLog, malformed <-chan string, requests chan<- AggregateLogs, results chan<- AggregateLogs, timeout time.Duration, allowedLateness int, windowSecond int, slideSecond int, wallClock bool) {
 
 	var log CommonLog
-	accuSections := make(map[string]int)
-	accuStatusCodes := make(map[string]int)
-	accuHttpMethods := make(map[string]int)
-	accuLogs := make([]CommonLog, 0)
-	curMin := 999999999999
-	curMax := -1
+	accuRejects := make(map[string]int)
+	windows := make(map[int][]CommonLog) // open windows by start epoch, a multiple of slideSecond
+	maxEpoch := -1    // watermark = maxEpoch - allowedLateness
+	closedUntil := -1 // windows ending at or before this epoch were flushed
+	lateDropped := 0
+	lateCorrected := 0
 	ok := true
 
-	// collect logs line by line
-	// flush data to summarizeLogs when either timed out or buffer max reached
+	// with -clock wall lines carry their arrival time
+	// and windows close on the ticker, idle or not
+	var tick <-chan time.Time
+	if wallClock {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// flush every open window ending at or before until, oldest first
+	flush := func(until int) {
+		starts := make([]int, 0, len(windows))
+		for start := range windows {
+			if start + windowSecond <= until {
+				starts = append(starts, start)
+			}
+		}
+		sort.Ints(starts)
+		for _, start := range starts {
+			accuLogs := windows[start]
+			end := start + windowSecond
+			results <- AggregateLogs{accuLogs, start, end, accuRejects, lateDropped, lateCorrected, false}
+
+			// sliding windows overlap. checkRules only gets the newest slide of each
+			// so every line is counted once. and no second past the newest line,
+			// so the final windows at end of input don't walk the rules through seconds without data
+			paneEnd := end
+			if paneEnd > maxEpoch + 1 {
+				paneEnd = maxEpoch + 1
+			}
+			if end - slideSecond < paneEnd {
+				pane := make([]CommonLog, 0)
+				for _, log := range accuLogs {
+					if epoch, _ := strconv.Atoi(log.epoch); epoch >= end - slideSecond {
+						pane = append(pane, log)
+					}
+				}
+				requests <- AggregateLogs{pane, end - slideSecond, paneEnd, accuRejects, lateDropped, lateCorrected, false}
+			}
+
+			// reset
+			delete(windows, start)
+			accuRejects = make(map[string]int)
+			lateDropped = 0
+			lateCorrected = 0
+		}
+		if until > closedUntil {
+			closedUntil = until
+		}
+	}
+
+	// collect logs line by line into event time windows aligned to epoch boundaries
+	// a window is flushed to summarizeLogs once the watermark passes its end
+	// live input that went quiet has no stragglers left to wait for, so on timeout
+	// the watermark moves up to the newest line. the window holding it stays open
 	for ok {
+		var idle <-chan time.Time // no timeout when 0
+		if timeout > 0 {
+			idle = time.After(timeout)
+		}
 		select {
-			case <- time.After(timeout): // reached timeout -> flush
-				results <- AggregateLogs{accuLogs, curMin, curMax}
+			case <- idle: // reached timeout -> flush the windows the newest line is past
+				flush(maxEpoch)
+
+			case now := <- tick: // wall clock second -> advance the watermark without waiting for a line
+				second := int(now.Unix())
+				last := second - second % slideSecond
+				if _, open := windows[last]; !open && last + windowSecond > closedUntil {
+					windows[last] = make([]CommonLog, 0) // reported even if no line arrives
+				}
+				if second > maxEpoch {
+					maxEpoch = second
+				}
+				flush(maxEpoch - allowedLateness)
 
-				// reset
-				accuLogs = make([]CommonLog, 0)
-				curMin = 999999999999
-				curMax = -1
+			case reason := <- malformed:
+				accuRejects[reason] += 1
 
 			case log, ok = <- logs:
+				if !ok {
+					// end of input. flush the final partial windows
+					flush(maxEpoch + windowSecond)
+					// and whatever was counted since, with no window left to report it
+					if len(accuRejects) > 0 || lateDropped > 0 || lateCorrected > 0 {
+						results <- AggregateLogs{nil, closedUntil, closedUntil, accuRejects, lateDropped, lateCorrected, true}
+					}
+					close(results)
+					close(requests)
+					break
+				}
 
-				// find 10 seconds window
+				// epoch was validated by the parser
+				// the line belongs to every window from last, its newest, back windowSecond
 				epoch, _ := strconv.Atoi(log.epoch)
-				if epoch > curMax {
-					curMax = epoch
-				}
-				if epoch < curMin {
-					curMin = epoch
-				}
-				if curMax - curMin < 10 {
-					accuLogs = append(accuLogs, log)
-				} else {
-					
-					// flush
-					// fmt.Println("ten seconds", curMax - curMin)
-					for _, log := range accuLogs {
-						accuSections[utils.ParseSection(log.requestResource)] += 1
-						accuStatusCodes[log.statusCode] += 1
-						accuHttpMethods[log.requestMethod] += 1
+				last := epoch - epoch % slideSecond
+				if last + windowSecond <= closedUntil {
+					// its windows were already flushed
+					lateDropped += 1
+					continue
+				}
+				if maxEpoch >= 0 && last < maxEpoch - maxEpoch % slideSecond {
+					// out of order, but its window is still open
+					lateCorrected += 1
+				}
+				for start := last; start > epoch - windowSecond; start -= slideSecond {
+					if start + windowSecond > closedUntil {
+						windows[start] = append(windows[start], log)
 					}
-					results <- AggregateLogs{accuLogs, curMin, curMax}
-					requests <- AggregateLogs{accuLogs, curMin, curMax}
+				}
 
-					// reset
-					accuLogs = make([]CommonLog, 0)
-					curMin = 999999999999
-					curMax = -1
+				if epoch > maxEpoch {
+					maxEpoch = epoch
+					flush(maxEpoch - allowedLateness)
 				}
 		}
 	}
 }
 
-func summarizeAggregatedLogs(aggregates <-chan AggregateLogs, alerts <-chan string, alertDurationSecond int, alertThreshold int) {
+func summarizeAggregatedLogs(aggregates <-chan AggregateLogs, alerts <-chan Alert, done chan<- bool, rules []alertRule, slideSecond int, topN int) {
 
 	ok := true 
 	accuSections := make(map[string]int)
 	accuStatusCodes := make(map[string]int)
 	accuHttpMethods := make(map[string]int)
-	var alert string
+	accuReferers := make(map[string]int)
+	accuUserAgents := make(map[string]int)
+	accuSources := make(map[string]int)
+	var alert Alert
 	var aggregate AggregateLogs
 	var alertStrBuilder strings.Builder // unbounded. risky. but make persisting alerts easy.
 	var strBuilder strings.Builder
+	printedAlerts := 0 // length of alertStrBuilder shown with the last window
+
+	// whole run totals. sliding windows overlap
+	// so each line is counted in the window whose newest slide holds it
+	run := utils.RunStats{
+		Start: -1,
+		End: -1,
+		Methods: make(map[string]int),
+		StatusCodes: make(map[string]int),
+		Sections: make(map[string]int),
+		Hosts: make(map[string]int),
+		Top: topN,
+	}
+	runSeconds := make(map[int]int) // requests per second
 
-	alertStrBuilder.WriteString(fmt.Sprintf("[High Threshold Alerts - total traffic > %d for past %d second]\n", alertThreshold, alertDurationSecond))
+	for _, rule := range rules {
+		alertStrBuilder.WriteString(fmt.Sprintf("[Alert rule %s]\n", rule))
+	}
 
-	for ok {
+	// run until both aggregateLogs and checkRules are done
+	for aggregates != nil || alerts != nil {
 		select {
 		
 		case aggregate, ok = <- aggregates:
+			if !ok {
+				aggregates = nil
+				break
+			}
+			if aggregate.leftover {
+				for _, v := range aggregate.rejects {
+					run.Malformed += v
+				}
+				run.LateDropped += aggregate.lateDropped
+				break
+			}
 
 			logs := aggregate.logs
 			startSecond := aggregate.startSec
@@ -115,129 +246,479 @@ func summarizeAggregatedLogs(aggregates <-chan AggregateLogs, alerts <-chan stri
 				accuSections[utils.ParseSection(log.requestResource)] += 1
 				accuStatusCodes[log.statusCode] += 1
 				accuHttpMethods[log.requestMethod] += 1
+				if log.referer != "" {
+					accuReferers[log.referer] += 1
+				}
+				if log.userAgent != "" {
+					accuUserAgents[log.userAgent] += 1
+				}
+				accuSources[log.source] += 1
+
+				if epoch, _ := strconv.Atoi(log.epoch); epoch >= endSecond - slideSecond {
+					run.Requests += 1
+					run.Methods[log.requestMethod] += 1
+					run.StatusCodes[log.statusCode] += 1
+					run.Sections[utils.ParseSection(log.requestResource)] += 1
+					run.Hosts[log.host] += 1
+					runSeconds[epoch] += 1
+					if runSeconds[epoch] > run.PeakRequests {
+						run.PeakRequests = runSeconds[epoch]
+						run.PeakSecond = epoch
+					}
+					if run.Start < 0 || epoch < run.Start {
+						run.Start = epoch
+					}
+					if epoch + 1 > run.End {
+						run.End = epoch + 1
+					}
+				}
+			}
+			for _, v := range aggregate.rejects {
+				run.Malformed += v
+			}
+			run.LateDropped += aggregate.lateDropped
+			if len(logs) > run.BusiestRequests {
+				run.BusiestRequests = len(logs)
+				run.BusiestStart = startSecond
+				run.BusiestEnd = endSecond
 			}
 
 			// build attributes statistics
-			strBuilder = utils.BuildWindowStat(strBuilder, accuHttpMethods, accuStatusCodes, accuSections, len(logs), startSecond, endSecond)
+			utils.BuildWindowStat(&strBuilder, accuHttpMethods, accuStatusCodes, accuSections, accuReferers, accuUserAgents, accuSources, aggregate.rejects, aggregate.lateDropped, aggregate.lateCorrected, len(logs), startSecond, endSecond)
 	
 			fmt.Println(alertStrBuilder.String())
 			fmt.Println(strBuilder.String())
+			printedAlerts = alertStrBuilder.Len()
 
 			// reset
 			strBuilder.Reset()
 			accuSections = make(map[string]int)
 			accuStatusCodes = make(map[string]int)
 			accuHttpMethods = make(map[string]int)
+			accuReferers = make(map[string]int)
+			accuUserAgents = make(map[string]int)
+			accuSources = make(map[string]int)
 
 		case alert, ok = <- alerts:
-			alertStrBuilder.WriteString(alert)
+			if !ok {
+				alerts = nil
+				break
+			}
+			alertStrBuilder.WriteString(alert.String())
+
+			// keep every alert from firing to resolving for the whole run report
+			if alert.end == 0 {
+				run.Alerts = append(run.Alerts, utils.AlertSpan{Name: alert.rule.name, Severity: alert.rule.severity, Metric: alert.rule.metric, Begin: alert.begin, End: -1, Value: alert.value})
+			} else {
+				for i := range run.Alerts {
+					if run.Alerts[i].Name == alert.rule.name && run.Alerts[i].Begin == alert.begin && run.Alerts[i].End < 0 {
+						run.Alerts[i].End = alert.end
+						run.Alerts[i].EndOfInput = alert.endOfInput
+					}
+				}
+			}
 		}
 	}
+
+	// alerts resolved at end of input come after the last window
+	if alertStrBuilder.Len() > printedAlerts {
+		fmt.Println(alertStrBuilder.String()[printedAlerts:])
+	}
+
+	// input ended. report the whole run
+	utils.BuildRunStat(&strBuilder, run)
+	fmt.Println(strBuilder.String())
+	close(done)
 }
 
-func checkThroughput(aggregates <-chan AggregateLogs, alerts chan<- string, alertDurationSecond int, alertThreshold int) {
+func checkRules(aggregates <-chan AggregateLogs, alerts chan<- Alert, rules []alertRule) {
 
-	// use a linked list as bucket to hold each second request count
-	// at each tick, deque oldest, and enque newest count
-	// to preserve window len
-	queue := list.New()
-	count := 0
-	running_total := 0
-	alertMode := false
-	var lastAlertTime int
+	// each rule state has a ring buffer of its matching hits per second of event time
+	// at each second, the count windowSecond ago drops out
+	// so the running total is exactly the past windowSecond seconds of the rule
+	groups := make([]*ruleGroup, len(rules))
+	for i, rule := range rules {
+		groups[i] = newRuleGroup(rule)
+	}
+	var states []*ruleState // of every group, in the order they were made
+	lastSecond := -1
+
+	// seconds without lines after which no state changes any more. the rings are empty,
+	// pending and flap holds have run out, so the rest of a gap is skipped
+	settle := 0
+	for _, rule := range rules {
+		if n := rule.windowSecond + rule.pendingSecond + rule.flapWindowSecond + 1; n > settle {
+			settle = n
+		}
+	}
 
 	for aggregate := range aggregates {
 
-		// add # of requests received past second
-		count = len(aggregate.logs)
-		queue.PushBack(count)
-		running_total += count
+		// aggregates are consecutive, non overlapping panes in event time order
+		counts := make(map[*ruleState]map[int]int)
+		volumes := make(map[*ruleState]map[int]int)
+		for _, log := range aggregate.logs {
+			epoch, _ := strconv.Atoi(log.epoch)
+			for _, group := range groups {
+				state, made := group.state(log)
+				if state == nil {
+					continue
+				}
+				if made {
+					states = append(states, state)
+				}
+				if counts[state] == nil {
+					counts[state] = make(map[int]int)
+					volumes[state] = make(map[int]int)
+				}
+				if state.rule.matches(log) {
+					counts[state][epoch] += 1
+				}
+				if state.volume != nil && state.rule.matchesExcept(log, "status") {
+					volumes[state][epoch] += 1
+				}
+			}
+		}
 
-		// if reached capacity, remove oldest node
-		// remove oldest count value from running total 
-		if queue.Len() > alertDurationSecond / 10 {
-			f := queue.Front()
-			v, _ := f.Value.(int)
-			running_total -= v
-			queue.Remove(f)
+		// walk every second since the last pane, gaps included, so an alert fires and
+		// resolves at the second its rule crosses the threshold
+		// a long gap, e.g. before a line with a corrupt future epoch, only until it settles
+		second := aggregate.startSec
+		if lastSecond >= 0 && lastSecond + 1 < second {
+			second = lastSecond + 1
+		}
+		settled := second + settle
+		for ; second < aggregate.endSec; second++ {
+			if second >= settled && second < aggregate.startSec {
+				second = aggregate.startSec // the rings clear in one Advance
+			}
+			for _, state := range states {
+				state.ring.Advance(second)
+				state.ring.Add(second, counts[state][second])
+				if state.volume != nil {
+					state.volume.Advance(second)
+					state.volume.Add(second, volumes[state][second])
+				}
+				if alert, ok := state.evaluate(second); ok {
+					alerts <- alert
+				}
+			}
+			lastSecond = second
 		}
 
-		// if running_total exceed threshold, print alert
-		if !alertMode && running_total > alertThreshold {
-			alertMode = true
-			lastAlertTime = aggregate.startSec
-			alerts <- fmt.Sprintf("High traffic generated an alert - hits = %d (threshold = %d, window_period = %d sec), triggered at %d\n", running_total, alertThreshold, alertDurationSecond, lastAlertTime)
-		} else if alertMode && running_total < alertThreshold {
-			alertMode = false
-			alerts <- fmt.Sprintf("High traffic alert resolved. Begin = %d, End = %d\n", lastAlertTime, aggregate.endSec)
+		// forget the idle states of by rules, so values that come and go don't pile up
+		evicted := make(map[*ruleState]bool)
+		for _, group := range groups {
+			group.evict(evicted)
+		}
+		if len(evicted) > 0 {
+			kept := states[:0]
+			for _, state := range states {
+				if !evicted[state] {
+					kept = append(kept, state)
+				}
+			}
+			states = kept
 		}
+	}
 
+	// end of input. nothing more will arrive to bring the values back
+	for _, state := range states {
+		if state.firing {
+			alerts <- Alert{state.rule, state.value(), state.begin, lastSecond + 1, true}
+		}
 	}
+	close(alerts)
 }
 
 func main() {
 
+	// subcommands. without one monitor reads its input as fast as it arrives
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			runMonitor("replay", os.Args[2:])
+			return
+		case "generate":
+			runGenerate(os.Args[2:])
+			return
+		}
+	}
+	runMonitor("monitor", os.Args[1:])
+}
+
+// replay paces the lines of historical logs by their epochs
+// so windows and alerts unfold as they did when the lines were logged
+func runMonitor(name string, args []string) {
 
-	var readSource *os.File
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var sources []*logSource
 	var inputFilePath string
 	var alertThreshold int
 	var alertWindowSecond int
-	flag.StringVar(&inputFilePath, "file", "os.Stdin", "input file path")
-	flag.IntVar(&alertThreshold, "threshold", 100, "Hits alert threshold.")
-	flag.IntVar(&alertWindowSecond,"window", 10, "Access log retention window second. Min 10 seconds - must be multiple of 10")
-	flag.Parse()
+	var inputFormat string
+	var logFormat string
+	var jsonMap string
+	var sniffLines int
+	var rejectsFilePath string
+	var follow bool
+	var listen string
+	var allowedLateness int
+	var windowSecond int
+	var slideSecond int
+	var topN int
+	var clock string
+	var speed string
+	var ruleSpecs ruleFlags
+	var errorRates string
+	var sectionThresholds string
+	var pendingSecond int
+	var recovery int
+	var flapCount int
+	var flapWindowSecond int
+	var errorWindowSecond int
+	var errorMinVolume int
+	fs.StringVar(&inputFilePath, "file", "os.Stdin", "input file path. Several comma separated paths or glob patterns are merged in timestamp order")
+	fs.BoolVar(&follow, "follow", false, "Keep reading -file as it grows, reopening it when rotated or truncated (tail -F)")
+	fs.StringVar(&listen, "listen", "", "Receive log lines over the network. syslog://:5514 accepts udp and tcp syslog (RFC 3164 and RFC 5424). http://:8080 accepts POSTed batches on /ingest. Comma separated for several")
+	fs.StringVar(&inputFormat, "format", "csv", "Input log format. csv, clf (apache common log format), combined (clf + referer and user-agent), nginx, jsonl (json lines) or auto (detect from the first lines)")
+	fs.StringVar(&logFormat, "logformat", "", "Apache LogFormat directive string. e.g. '%h %l %u %t \"%r\" %>s %b %D'. Overrides -format, except auto and jsonl. With -format nginx an nginx log_format string (default combined)")
+	fs.StringVar(&jsonMap, "jsonmap", "", "Json key path of each column with -format jsonl. e.g. host=client.ip,epoch=ts,status=http.status")
+	fs.IntVar(&sniffLines, "sniff", 10, "Number of lines -format auto detects the format from")
+	fs.StringVar(&rejectsFilePath, "rejects", "", "Write malformed lines with the reason they were rejected to this file")
+	fs.StringVar(&clock, "clock", "event", "Time windows are on. event (each line's own timestamp, for replaying files) or wall (arrival time, windows close on a real ticker)")
+	fs.IntVar(&topN, "top", 5, "Number of sections and hosts listed in the whole run report")
+	fs.IntVar(&allowedLateness, "lateness", 5, "Seconds an out of order line may lag the newest one and still be counted in its own window")
+	fs.IntVar(&alertThreshold, "threshold", 100, "Hits alert threshold. Of the default rule, used without -rule")
+	fs.IntVar(&alertWindowSecond,"window", 10, "Hits alert window second. The alert counts the hits of exactly this many past seconds. Default window of -rule")
+	fs.Var(&ruleSpecs, "rule", "Alert rule as comma separated key=value pairs. Repeatable. name, metric (hits, rate or ratio of status), section (as in the window stats. /api/user is user), method, status (500 or 5xx), host, window, op (>, >=, <, <=), threshold, severity, min (requests a ratio needs), by (a filter alerted on per value), for, recovery, flap and flap-window. e.g. 'name=user errors,section=user,status=5xx,window=60,op=>,threshold=50,severity=critical'")
+	fs.IntVar(&pendingSecond, "for", 0, "Seconds a threshold must stay breached before the alert fires")
+	fs.IntVar(&recovery, "recovery", -1, "Hits the -threshold alert resolves below. Lower than -threshold to keep traffic hovering near it from flapping. Default -threshold")
+	fs.IntVar(&flapCount, "flap", 0, "Threshold crossings within -flap-window that make an alert flapping. A flapping alert only resolves once recovered for -flap-window. 0 is off")
+	fs.IntVar(&flapWindowSecond, "flap-window", 60, "Flap detection window second")
+	fs.StringVar(&sectionThresholds, "section-thresholds", "", "Hits alert threshold of each section over -window, each alerted on its own. * for unlisted sections. A section without hits in the window and no alert is forgotten until it comes back. e.g. report=20,help=200,*=100")
+	fs.StringVar(&errorRates, "error-rate", "", "Alert when the share of 5xx or 4xx responses exceeds a ratio. e.g. 5xx=0.05,4xx=0.2")
+	fs.IntVar(&errorWindowSecond, "error-window", 60, "Error rate alert window second")
+	fs.IntVar(&errorMinVolume, "error-min", 20, "Requests an error rate alert window needs before the ratio counts")
+	fs.IntVar(&windowSecond, "window-size", 10, "Report window length second")
+	fs.IntVar(&slideSecond, "window-slide", 0, "Seconds between sliding windows. Must divide -window-size. Default -window-size (tumbling)")
+	if name == "replay" {
+		fs.StringVar(&speed, "speed", "1x", "Replay speed factor. 10x plays 10 seconds of the log each second")
+	}
+	fs.Parse(args)
 
 	// positional arg 1 is file path
 	// if no positional arg is set
 	// then default to stdin as input source
-	fmt.Println(flag.NFlag())
-	fmt.Println(flag.NArg())
-	args := os.Args[1:]
-	fmt.Println(args)
+	if fs.NArg() > 0 {
+		if inputFilePath != "os.Stdin" {
+			utils.PrintMsgExit("error: give input files either with -file or as arguments")
+		}
+		inputFilePath = strings.Join(fs.Args(), ",")
+	}
 
 	if inputFilePath == "os.Stdin" { 
-		readSource = os.Stdin
+		if follow {
+			utils.PrintMsgExit("error: -follow requires -file")
+		}
+		// a listener replaces stdin as the default input
+		if listen == "" {
+			sources = append(sources, &logSource{"stdin", bufio.NewScanner(os.Stdin), nil})
+		}
 	} else {
-		utils.ValidateFilePath(inputFilePath)
-		f, err := os.Open(inputFilePath)
-		utils.Check(err)
-		readSource = f
-		defer f.Close()
+		paths, err := utils.ExpandPaths(inputFilePath)
+		if err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+		}
+		for _, path := range paths {
+			src, closer, err := openSource(path, follow)
+			utils.Check(err)
+			defer closer.Close()
+			sources = append(sources, src)
+		}
+	}
+	if listen != "" {
+		listeners, err := listenSources(listen)
+		if err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+		}
+		sources = append(sources, listeners...)
 	}
 	aggregateTimeout := 5 * time.Second
+	if name == "replay" {
+		// a gap in the log is replayed as a pause. the lines after it are still on their way
+		aggregateTimeout = 0
+	}
+
+	if slideSecond == 0 {
+		slideSecond = windowSecond
+	}
+	if windowSecond <= 0 || slideSecond <= 0 || windowSecond % slideSecond != 0 {
+		utils.PrintMsgExit("error: window-slide must be positive and divide window-size")
+	}
+	if allowedLateness < 0 {
+		utils.PrintMsgExit("error: lateness must not be negative")
+	}
+	if alertWindowSecond <= 0 {
+		utils.PrintMsgExit("error: alertWindowSecond must be positive")
+	}
+
+	// -for and -flap hold the rules of -threshold, -section-thresholds and -error-rate
+	// -rule sets its own
+	if pendingSecond < 0 || flapCount < 0 || (flapCount > 0 && flapWindowSecond <= 0) {
+		utils.PrintMsgExit("error: -for and -flap must not be negative, and -flap needs a positive -flap-window")
+	}
+	hold := func(rule alertRule) alertRule {
+		rule.pendingSecond = pendingSecond
+		rule.flapCount = flapCount
+		rule.flapWindowSecond = flapWindowSecond
+		return rule
+	}
+
+	// without -rule, the one high traffic rule of -threshold and -window
+	var rules []alertRule
+	if len(ruleSpecs) == 0 {
+		rule := hold(alertRule{name: "High traffic", metric: "hits", filter: map[string]string{}, windowSecond: alertWindowSecond, comparison: ">", threshold: float64(alertThreshold), severity: "warning"})
+		if recovery >= 0 {
+			rule.recovery, rule.hasRecovery = float64(recovery), true
+		}
+		if err := rule.checkHysteresis(); err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+		}
+		rules = append(rules, rule)
+	} else {
+		names := make(map[string]bool)
+		for _, spec := range ruleSpecs {
+			rule, err := parseRule(spec, alertWindowSecond)
+			if err != nil {
+				utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+			}
+			if names[rule.name] {
+				utils.PrintMsgExit(fmt.Sprintf("error: duplicate rule name %s", rule.name))
+			}
+			names[rule.name] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	// per section and error rate rules come on top of the traffic rules
+	if sectionThresholds != "" {
+		rule, err := sectionThresholdRule(sectionThresholds, alertWindowSecond)
+		if err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: section-thresholds: %s", err))
+		}
+		rules = append(rules, hold(rule))
+	}
+	if errorWindowSecond <= 0 || errorMinVolume < 0 {
+		utils.PrintMsgExit("error: error-window must be positive and error-min not negative")
+	}
+	errorRules, err := errorRateRules(errorRates, errorWindowSecond, errorMinVolume)
+	if err != nil {
+		utils.PrintMsgExit(fmt.Sprintf("error: error-rate: %s", err))
+	}
+	for _, rule := range errorRules {
+		rules = append(rules, hold(rule))
+	}
+
+	replaySpeed := 0.0
+	if name == "replay" {
+		if follow || listen != "" {
+			utils.PrintMsgExit("error: replay reads files. -follow and -listen are live")
+		}
+		factor, err := utils.ParseSpeed(speed)
+		if err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+		}
+		replaySpeed = factor
+	}
 
-	if math.Mod(float64(alertWindowSecond), 10) != 0 {
-		utils.PrintMsgExit("error: alertWindowSecond must be multiple of 10")
+	if clock != "event" && clock != "wall" {
+		utils.PrintMsgExit("error: -clock must be event or wall")
+	}
+	wallClock := clock == "wall"
+	if wallClock && replaySpeed > 0 {
+		utils.PrintMsgExit("error: replay paces lines by their own epochs. -clock wall cannot be used")
+	}
+
+	if inputFormat == "auto" && logFormat != "" {
+		utils.PrintMsgExit("error: -format auto cannot be used with -logformat")
+	}
+	if inputFormat == "jsonl" && logFormat != "" {
+		utils.PrintMsgExit("error: -format jsonl cannot be used with -logformat. use -jsonmap")
 	}
 
 	// init channels
 	logs := make(chan CommonLog)
+	malformed := make(chan string)
 	logResults := make(chan AggregateLogs)
 	requests := make(chan AggregateLogs)
-	alerts := make(chan string)
+	alerts := make(chan Alert)
+	done := make(chan bool)
 
 	// execute coroutines
-	go aggregateLogs(logs, requests, logResults, aggregateTimeout)
-	go summarizeAggregatedLogs(logResults, alerts, alertWindowSecond, alertThreshold)
-	go checkThroughput(requests, alerts, alertWindowSecond, alertThreshold)
+	go aggregateLogs(logs, malformed, requests, logResults, aggregateTimeout, allowedLateness, windowSecond, slideSecond, wallClock)
+	go summarizeAggregatedLogs(logResults, alerts, done, rules, slideSecond, topN)
+	go checkRules(requests, alerts, rules)
+
+	// sniff the first lines of the first input for the format
+	// they are parsed again once the format is known
+	if inputFormat == "auto" {
+		sniffed := sources[0].sniff(sniffLines)
+		format, ok := detectFormat(sniffed, jsonMap, wallClock)
+		if !ok {
+			utils.PrintMsgExit(fmt.Sprintf("error: no known format matches the first %d lines", len(sniffed)))
+		}
+		fmt.Fprintln(os.Stderr, "input format:", format)
+		inputFormat = format
+	}
+
+	// parse each input on its own coroutine
+	streams := make([]chan parsedLine, len(sources))
+	for i, src := range sources {
+		parseLine, err := newLineParser(inputFormat, logFormat, jsonMap, wallClock)
+		if err != nil {
+			utils.PrintMsgExit(fmt.Sprintf("error: %s", err))
+		}
+		streams[i] = make(chan parsedLine)
+		go src.parse(parseLine, streams[i])
+	}
+
+	// unbuffered. live inputs only end on an interrupt, which skips any deferred flush
+	var rejects *os.File
+	if rejectsFilePath != "" {
+		var err error
+		rejects, err = os.Create(rejectsFilePath)
+		utils.Check(err)
+		defer rejects.Close()
+	}
 
-	// listen to stdin
-	scanner := bufio.NewScanner(readSource)
-	for scanner.Scan() {
-		line := strings.ReplaceAll(scanner.Text(), "\"", "")
-		// requestCounts <- 1
-		m := strings.Split(line, ",")
-		if len(m) == 7 {
-			req := strings.Fields(m[4])
-			if len(req) == 3 {
-				cl := CommonLog{m[0],m[1],m[2],m[3],req[0],req[1],req[2],m[5],m[6]}
-				logs <- cl
+	// parsed lines go to aggregateLogs
+	// malformed ones are counted by reason and written to -rejects
+	ingest := func(pl parsedLine) {
+		if pl.err == nil {
+			logs <- pl.log
+		} else if pl.err != errCSVHeader {
+			malformed <- pl.err.Error()
+			if rejects != nil {
+				fmt.Fprintf(rejects, "%s\t%s\t%s\n", pl.log.source, pl.err, pl.raw)
 			}
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+	if replaySpeed > 0 {
+		ingest = pace(ingest, replaySpeed)
 	}
+
+	// merged by epoch so windows and alerts cover every input
+	// live inputs never end, so they are taken as they arrive
+	// as are lines stamped with the wall clock
+	if follow || listen != "" || wallClock {
+		fanIn(streams, ingest)
+	} else {
+		mergeByEpoch(streams, ingest)
+	}
+
+	// end of input. let the final windows and alerts drain before exiting
+	close(logs)
+	<-done
 } 
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// a line to feed aggregateLogs, and how long to go quiet after it
+type timedLine struct {
+	epoch int
+	pause time.Duration
+}
+
+// run aggregateLogs over the lines and collect the windows and panes it flushes
+func runAggregate(lines []timedLine, timeout time.Duration, allowedLateness int, windowSecond int, slideSecond int) ([]AggregateLogs, []AggregateLogs) {
+	logs := make(chan CommonLog)
+	malformed := make(chan string)
+	requests := make(chan AggregateLogs)
+	results := make(chan AggregateLogs)
+	go aggregateLogs(logs, malformed, requests, results, timeout, allowedLateness, windowSecond, slideSecond, false)
+
+	var windows, panes []AggregateLogs
+	done := make(chan bool)
+	go func() {
+		for pane := range requests {
+			panes = append(panes, pane)
+		}
+		close(done)
+	}()
+	go func() {
+		for _, line := range lines {
+			logs <- CommonLog{epoch: strconv.Itoa(line.epoch), statusCode: "200"}
+			time.Sleep(line.pause)
+		}
+		close(logs)
+	}()
+	for window := range results {
+		windows = append(windows, window)
+	}
+	<-done
+	return windows, panes
+}
+
+func epochs(logs []CommonLog) []int {
+	var out []int
+	for _, log := range logs {
+		epoch, _ := strconv.Atoi(log.epoch)
+		out = append(out, epoch)
+	}
+	return out
+}
+
+func sameInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAggregateLogsTumbling(t *testing.T) {
+	lines := []timedLine{{1000, 0}, {1005, 0}, {1012, 0}, {1003, 0}, {1025, 0}, {1001, 0}}
+	windows, panes := runAggregate(lines, time.Minute, 5, 10, 10)
+
+	want := []struct {
+		start         int
+		epochs        []int
+		lateDropped   int
+		lateCorrected int
+	}{
+		{1000, []int{1000, 1005, 1003}, 0, 1},
+		{1010, []int{1012}, 0, 0},
+		{1020, []int{1025}, 1, 0},
+	}
+	if len(windows) != len(want) || len(panes) != len(want) {
+		t.Fatalf("aggregateLogs failed. Want: %d windows. Got: %d windows, %d panes", len(want), len(windows), len(panes))
+	}
+	for i, w := range want {
+		got := windows[i]
+		if got.startSec != w.start || got.endSec != w.start+10 || !sameInts(epochs(got.logs), w.epochs) || got.lateDropped != w.lateDropped || got.lateCorrected != w.lateCorrected {
+			t.Errorf("aggregateLogs failed. Want: %v. Got: [%d, %d) %v late %d/%d", w, got.startSec, got.endSec, epochs(got.logs), got.lateDropped, got.lateCorrected)
+		}
+	}
+}
+
+// a quiet spell in the middle of a window must not close it
+func TestAggregateLogsTimeout(t *testing.T) {
+	lines := []timedLine{{1000, 100 * time.Millisecond}, {1007, 0}, {1008, 0}}
+	windows, _ := runAggregate(lines, 20*time.Millisecond, 5, 10, 10)
+
+	if len(windows) != 1 || !sameInts(epochs(windows[0].logs), []int{1000, 1007, 1008}) || windows[0].lateDropped != 0 {
+		t.Errorf("aggregateLogs failed. Want: one window of 1000, 1007, 1008. Got: %v", windows)
+	}
+}
+
+func TestAggregateLogsSliding(t *testing.T) {
+	lines := []timedLine{{1000, 0}, {1006, 0}}
+	windows, panes := runAggregate(lines, time.Minute, 0, 10, 5)
+
+	want := [][]int{{1000}, {1000, 1006}, {1006}}
+	if len(windows) != len(want) {
+		t.Fatalf("aggregateLogs failed. Want: %d windows. Got: %v", len(want), windows)
+	}
+	for i, w := range want {
+		if windows[i].startSec != 995+5*i || !sameInts(epochs(windows[i].logs), w) {
+			t.Errorf("aggregateLogs failed. Want: start %d %v. Got: start %d %v", 995+5*i, w, windows[i].startSec, epochs(windows[i].logs))
+		}
+	}
+	// each line is in exactly one pane
+	var counted []int
+	for _, pane := range panes {
+		counted = append(counted, epochs(pane.logs)...)
+	}
+	if !sameInts(counted, []int{1000, 1006}) {
+		t.Errorf("aggregateLogs failed. Want: panes of 1000, 1006. Got: %v", counted)
+	}
+	// and none runs past the newest line
+	if last := panes[len(panes)-1]; len(panes) != 2 || last.startSec != 1005 || last.endSec != 1007 {
+		t.Errorf("aggregateLogs failed. Want: 2 panes, the last [1005, 1007). Got: %v", panes)
+	}
+}
+
+// an alert still firing when the data ends resolves at its end, marked as end of input
+func TestCheckRulesEndOfInput(t *testing.T) {
+	var lines []timedLine
+	for second := 1000; second < 1030; second++ {
+		for i := 0; i < 5; i++ {
+			lines = append(lines, timedLine{second, 0})
+		}
+	}
+	_, panes := runAggregate(lines, time.Minute, 0, 20, 5)
+
+	aggregates := make(chan AggregateLogs)
+	alerts := make(chan Alert)
+	rule := alertRule{name: "High traffic", metric: "hits", windowSecond: 20, comparison: ">", threshold: 50, severity: "warning"}
+	go checkRules(aggregates, alerts, []alertRule{rule})
+	go func() {
+		for _, pane := range panes {
+			aggregates <- pane
+		}
+		close(aggregates)
+	}()
+
+	var got []Alert
+	for alert := range alerts {
+		got = append(got, alert)
+	}
+	if len(got) != 2 || got[0].begin != 1010 || got[1].end != 1030 || !got[1].endOfInput {
+		t.Errorf("checkRules failed. Want: fire at 1010, resolve at 1030 at end of input. Got: %v", got)
+	}
+}
+
+// rejects counted after the last window still reach the run report
+func TestAggregateLogsLeftover(t *testing.T) {
+	logs := make(chan CommonLog)
+	malformed := make(chan string)
+	requests := make(chan AggregateLogs)
+	results := make(chan AggregateLogs)
+	go aggregateLogs(logs, malformed, requests, results, time.Minute, 5, 10, 10, false)
+	go func() {
+		for range requests {
+		}
+	}()
+	go func() {
+		malformed <- "bad epoch"
+		malformed <- "bad epoch"
+		close(logs)
+	}()
+
+	var got []AggregateLogs
+	for window := range results {
+		got = append(got, window)
+	}
+	if len(got) != 1 || !got[0].leftover || got[0].rejects["bad epoch"] != 2 || len(got[0].logs) != 0 {
+		t.Errorf("aggregateLogs failed. Want: one leftover of 2 rejects. Got: %v", got)
+	}
+}
+
+// a line a billion seconds ahead must not make checkRules walk every second up to it
+func TestCheckRulesGap(t *testing.T) {
+	var burst []CommonLog
+	for i := 0; i < 6; i++ {
+		burst = append(burst, CommonLog{epoch: "1000", statusCode: "200"})
+	}
+	far := 1000 + 1000000000
+	panes := []AggregateLogs{
+		{logs: burst, startSec: 1000, endSec: 1010},
+		{logs: []CommonLog{{epoch: strconv.Itoa(far), statusCode: "200"}}, startSec: far, endSec: far + 1},
+	}
+
+	aggregates := make(chan AggregateLogs)
+	alerts := make(chan Alert)
+	rule := alertRule{name: "High traffic", metric: "hits", windowSecond: 10, comparison: ">", threshold: 5, severity: "warning", pendingSecond: 2, flapCount: 2, flapWindowSecond: 30}
+	go checkRules(aggregates, alerts, []alertRule{rule})
+	go func() {
+		for _, pane := range panes {
+			aggregates <- pane
+		}
+		close(aggregates)
+	}()
+
+	var got []Alert
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case alert, ok := <-alerts:
+			if !ok {
+				done = true
+				break
+			}
+			got = append(got, alert)
+		case <-timeout:
+			t.Fatalf("checkRules failed. Want: done within 5s. Got: %v so far", got)
+		}
+	}
+	if len(got) != 2 || got[0].begin != 1002 || got[1].end != 1030 || got[1].endOfInput {
+		t.Errorf("checkRules failed. Want: fire at 1002, resolve at 1030 in the gap once the flap hold runs out. Got: %v", got)
+	}
+}
+
+// with -clock wall the ticker closes windows, and reports them, with no line arriving
+func TestAggregateLogsWallClock(t *testing.T) {
+	logs := make(chan CommonLog)
+	malformed := make(chan string)
+	requests := make(chan AggregateLogs, 10)
+	results := make(chan AggregateLogs)
+	go aggregateLogs(logs, malformed, requests, results, 0, 0, 1, 1, true)
+
+	select {
+	case window := <-results:
+		now := int(time.Now().Unix())
+		if len(window.logs) != 0 || window.endSec-window.startSec != 1 || window.endSec > now {
+			t.Errorf("aggregateLogs failed. Want: an empty, closed 1 second window. Got: %v at %d", window, now)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("aggregateLogs failed. Want: a window within 3s without any line")
+	}
+	close(logs)
+	for range results {
+	}
+}
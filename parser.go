@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/NotHere1/monitor/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type lineParser func(line string) (CommonLog, error)
+
+// reasons a line is rejected. reported per window and written to -rejects
+var (
+	errColumnCount = errors.New("wrong column count")
+	errNoMatch     = errors.New("does not match format")
+	errRequestLine = errors.New("bad request line")
+	errEpoch       = errors.New("bad epoch")
+	errStatus      = errors.New("bad status")
+)
+
+// the csv header row is consumed for its column names. it is not a rejected line
+var errCSVHeader = errors.New("csv header")
+
+// host ident user [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326
+const clfLogFormat = `%h %l %u %t "%r" %>s %b`
+
+// clf followed by "Referer" "User-Agent"
+const combinedLogFormat = clfLogFormat + ` "%{Referer}i" "%{User-agent}i"`
+
+// nginx's predefined combined log_format
+const nginxCombinedFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+// a non empty logFormat (apache LogFormat directive string) takes precedence over format
+// with format nginx, logFormat is an nginx log_format string instead
+// jsonMap overrides the json key path of CommonLog columns with format jsonl
+// wallClock stamps each line with its arrival time. its own timestamp, valid or not, is ignored
+func newLineParser(format string, logFormat string, jsonMap string, wallClock bool) (lineParser, error) {
+	parseLine, err := newFormatParser(format, logFormat, jsonMap)
+	if err != nil {
+		return nil, err
+	}
+	return func(line string) (CommonLog, error) {
+		cl, err := parseLine(line)
+		if err != nil {
+			return cl, err
+		}
+		if wallClock {
+			cl.epoch = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		return cl, validateLog(cl)
+	}, nil
+}
+
+// epoch and status are checked alike for every format
+func validateLog(cl CommonLog) error {
+	if _, err := strconv.Atoi(cl.epoch); err != nil {
+		return errEpoch
+	}
+	if status, err := strconv.Atoi(cl.statusCode); err != nil || status < 100 || status > 599 {
+		return errStatus
+	}
+	return nil
+}
+
+func newFormatParser(format string, logFormat string, jsonMap string) (lineParser, error) {
+	if format == "jsonl" {
+		return newJSONParser(jsonMap)
+	}
+
+	compile := utils.CompileLogFormat
+	if format == "nginx" {
+		compile = utils.CompileNginxFormat
+		if logFormat == "" {
+			logFormat = nginxCombinedFormat
+		}
+	} else if logFormat == "" {
+		switch format {
+		case "csv":
+			return newCSVParser(), nil
+		case "clf":
+			logFormat = clfLogFormat
+		case "combined":
+			logFormat = combinedLogFormat
+		default:
+			return nil, fmt.Errorf("unknown input format: %s", format)
+		}
+	}
+
+	lf, err := compile(logFormat)
+	if err != nil {
+		return nil, err
+	}
+	return func(line string) (CommonLog, error) {
+		fields, ok := lf.Parse(line)
+		if !ok {
+			return CommonLog{}, errNoMatch
+		}
+		return logFormatFields(fields)
+	}, nil
+}
+
+// formats tried by -format auto, in order of preference when several parse as many lines
+// nginx's predefined combined log_format is the apache one, so those logs are found as combined
+var autoFormats = []string{"jsonl", "csv", "combined", "clf", "nginx"}
+
+// pick the format that parses the most of the sniffed lines
+func detectFormat(lines []string, jsonMap string, wallClock bool) (string, bool) {
+	best, bestCount := "", 0
+	for _, format := range autoFormats {
+		parseLine, err := newLineParser(format, "", jsonMap, wallClock)
+		if err != nil {
+			continue
+		}
+		count := 0
+		for _, line := range lines {
+			if _, err := parseLine(line); err == nil || err == errCSVHeader {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = format, count
+		}
+	}
+	return best, bestCount > 0
+}
+
+// column names of the sample_data/apache.log header row
+// used until the input provides a header row of its own
+var csvColumns = []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes"}
+
+// header names accepted for each CommonLog column
+var csvColumnAliases = map[string]string{
+	"host":       "remotehost",
+	"user":       "authuser",
+	"username":   "authuser",
+	"epoch":      "date",
+	"time":       "date",
+	"statuscode": "status",
+	"referrer":   "referer",
+	"user-agent": "useragent",
+	"user_agent": "useragent",
+}
+
+// rfc 4180 csv. columns are mapped by the name given in the header row
+// so reordered and extra columns are fine
+type csvParser struct {
+	columns []string
+}
+
+func newCSVParser() lineParser {
+	p := &csvParser{csvColumns}
+	return p.parse
+}
+
+func (p *csvParser) parse(line string) (CommonLog, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	record, err := r.Read()
+	if err != nil {
+		return CommonLog{}, errNoMatch
+	}
+
+	if columns, ok := csvHeader(record); ok {
+		p.columns = columns
+		return CommonLog{}, errCSVHeader
+	}
+	if len(record) != len(p.columns) {
+		return CommonLog{}, errColumnCount
+	}
+
+	cl := CommonLog{extra: make(map[string]string)}
+	for idx, column := range p.columns {
+		v := record[idx]
+		switch column {
+		case "remotehost":
+			cl.host = v
+		case "rfc931":
+			cl.rfc931 = v
+		case "authuser":
+			cl.username = v
+		case "date":
+			cl.epoch = v
+		case "request":
+			req := strings.Fields(v)
+			if len(req) != 3 {
+				return CommonLog{}, errRequestLine
+			}
+			cl.requestMethod, cl.requestResource, cl.requestProtocol = req[0], req[1], req[2]
+		case "status":
+			cl.statusCode = v
+		case "bytes":
+			cl.bytes = v
+		case "referer":
+			cl.referer = v
+		case "useragent":
+			cl.userAgent = v
+		default:
+			cl.extra[column] = v
+		}
+	}
+	if cl.requestMethod == "" {
+		return CommonLog{}, errRequestLine
+	}
+	return cl, nil
+}
+
+// a header row names at least the request and status columns
+func csvHeader(record []string) ([]string, bool) {
+	columns := make([]string, len(record))
+	found := 0
+	for idx, name := range record {
+		column := strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := csvColumnAliases[column]; ok {
+			column = alias
+		}
+		if column == "request" || column == "status" {
+			found++
+		}
+		columns[idx] = column
+	}
+	return columns, found == 2
+}
+
+// json key path of each CommonLog column, overridden by -jsonmap
+// request may be given whole, or split into method, resource and protocol
+var jsonDefaultPaths = map[string]string{
+	"host":      "host",
+	"rfc931":    "rfc931",
+	"username":  "username",
+	"epoch":     "epoch",
+	"request":   "request",
+	"method":    "method",
+	"resource":  "resource",
+	"protocol":  "protocol",
+	"status":    "status",
+	"bytes":     "bytes",
+	"referer":   "referer",
+	"useragent": "useragent",
+}
+
+// json lines. one object per line
+func newJSONParser(jsonMap string) (lineParser, error) {
+	paths := make(map[string]string)
+	for column, path := range jsonDefaultPaths {
+		paths[column] = path
+	}
+	overrides, err := utils.ParseKeyValues(jsonMap)
+	if err != nil {
+		return nil, fmt.Errorf("jsonmap: %s", err)
+	}
+	for column, path := range overrides {
+		if _, ok := paths[column]; !ok {
+			return nil, fmt.Errorf("jsonmap: unknown column %q", column)
+		}
+		paths[column] = path
+	}
+
+	return func(line string) (CommonLog, error) {
+		var obj map[string]interface{}
+		d := json.NewDecoder(strings.NewReader(line))
+		d.UseNumber()
+		if err := d.Decode(&obj); err != nil {
+			return CommonLog{}, errNoMatch
+		}
+		lookup := func(column string) (interface{}, bool) {
+			return utils.LookupJSONPath(obj, paths[column])
+		}
+		field := func(column string) string {
+			v, _ := lookup(column)
+			return utils.JSONString(v)
+		}
+
+		cl := CommonLog{
+			host:            field("host"),
+			rfc931:          field("rfc931"),
+			username:        field("username"),
+			requestMethod:   field("method"),
+			requestResource: field("resource"),
+			requestProtocol: field("protocol"),
+			statusCode:      field("status"),
+			bytes:           field("bytes"),
+			referer:         field("referer"),
+			userAgent:       field("useragent"),
+		}
+		if request := field("request"); request != "" {
+			req := strings.Fields(request)
+			if len(req) != 3 {
+				return CommonLog{}, errRequestLine
+			}
+			cl.requestMethod, cl.requestResource, cl.requestProtocol = req[0], req[1], req[2]
+		}
+		if cl.requestMethod == "" || cl.requestResource == "" {
+			return CommonLog{}, errRequestLine
+		}
+		// a missing or bad timestamp leaves epoch empty for validateLog
+		if ts, ok := lookup("epoch"); ok {
+			if tm, err := utils.ParseJSONTime(ts); err == nil {
+				cl.epoch = strconv.FormatInt(tm.Unix(), 10)
+			}
+		}
+		return cl, nil
+	}, nil
+}
+
+// build CommonLog from the fields of a compiled LogFormat
+// the bracketed %t timestamp is converted to the epoch aggregateLogs windows on
+// a bad timestamp leaves epoch empty for validateLog
+// directives without a CommonLog column (%D, %v, %{X-Forwarded-For}i ...) are kept in extra
+// as are nginx variables without an apache equivalent ($request_time ...)
+func logFormatFields(fields map[string]string) (CommonLog, error) {
+	cl := CommonLog{extra: make(map[string]string)}
+	for k, v := range fields {
+		switch k {
+		case "h":
+			cl.host = v
+		case "l":
+			cl.rfc931 = v
+		case "u":
+			cl.username = v
+		case "t":
+			if tm, err := utils.ParseCLFTime(v); err == nil {
+				cl.epoch = strconv.FormatInt(tm.Unix(), 10)
+			}
+		case "time_iso8601":
+			if tm, err := time.Parse(time.RFC3339, v); err == nil {
+				cl.epoch = strconv.FormatInt(tm.Unix(), 10)
+			}
+		case "msec":
+			if sec, err := strconv.ParseFloat(v, 64); err == nil {
+				cl.epoch = strconv.FormatInt(int64(sec), 10)
+			}
+		case "r":
+			req := strings.Fields(v)
+			if len(req) != 3 {
+				return CommonLog{}, errRequestLine
+			}
+			cl.requestMethod, cl.requestResource, cl.requestProtocol = req[0], req[1], req[2]
+		case "m":
+			cl.requestMethod = v
+		case "U":
+			cl.requestResource = v
+		case "H":
+			cl.requestProtocol = v
+		case "s":
+			cl.statusCode = v
+		case "b", "B":
+			cl.bytes = v
+		case "{referer}i":
+			cl.referer = v
+		case "{user-agent}i":
+			cl.userAgent = v
+		default:
+			cl.extra[k] = v
+		}
+	}
+	if cl.requestMethod == "" || cl.requestResource == "" {
+		return CommonLog{}, errRequestLine
+	}
+	return cl, nil
+}
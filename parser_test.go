@@ -0,0 +1,227 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// each case is one input. its lines go through the same parser, header rows included
+func TestCSVParser(t *testing.T) {
+	cases := []struct {
+		name  string
+		lines []string
+		want  CommonLog
+		err   error
+	}{
+		{
+			"sample_data header",
+			[]string{`"remotehost","rfc931","authuser","date","request","status","bytes"`, `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`},
+			CommonLog{host: "10.0.0.2", rfc931: "-", username: "apache", epoch: "1549573860", requestMethod: "GET", requestResource: "/api/user", requestProtocol: "HTTP/1.0", statusCode: "200", bytes: "1234"},
+			nil,
+		},
+		{
+			"no header",
+			[]string{`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`},
+			CommonLog{host: "10.0.0.2", rfc931: "-", username: "apache", epoch: "1549573860", requestMethod: "GET", requestResource: "/api/user", requestProtocol: "HTTP/1.0", statusCode: "200", bytes: "1234"},
+			nil,
+		},
+		{
+			"aliases",
+			[]string{`Host,User,Epoch,Request,StatusCode,Referrer,User-Agent`, `10.0.0.3,bob,1549573861,POST /report HTTP/1.1,503,http://a/,curl/7.64`},
+			CommonLog{host: "10.0.0.3", username: "bob", epoch: "1549573861", requestMethod: "POST", requestResource: "/report", requestProtocol: "HTTP/1.1", statusCode: "503", referer: "http://a/", userAgent: "curl/7.64"},
+			nil,
+		},
+		{
+			"reordered and extra columns",
+			[]string{`status,request_id,request,time,host`, `404,abc123,GET /api/help HTTP/1.0,1549573862,10.0.0.4`},
+			CommonLog{host: "10.0.0.4", epoch: "1549573862", requestMethod: "GET", requestResource: "/api/help", requestProtocol: "HTTP/1.0", statusCode: "404", extra: map[string]string{"request_id": "abc123"}},
+			nil,
+		},
+		{
+			"quoted comma",
+			[]string{`host,date,request,status,useragent`, `10.0.0.5,1549573863,"GET /a,b HTTP/1.0",200,"Mozilla/5.0 (X11, Linux)"`},
+			CommonLog{host: "10.0.0.5", epoch: "1549573863", requestMethod: "GET", requestResource: "/a,b", requestProtocol: "HTTP/1.0", statusCode: "200", userAgent: "Mozilla/5.0 (X11, Linux)"},
+			nil,
+		},
+		{
+			"escaped quotes",
+			[]string{`host,date,request,status,useragent`, `10.0.0.6,1549573864,"GET /q HTTP/1.0",200,"say ""hi"""`},
+			CommonLog{host: "10.0.0.6", epoch: "1549573864", requestMethod: "GET", requestResource: "/q", requestProtocol: "HTTP/1.0", statusCode: "200", userAgent: `say "hi"`},
+			nil,
+		},
+		{
+			"wrong column count",
+			[]string{`host,date,request,status`, `10.0.0.7,1549573865,GET / HTTP/1.0`},
+			CommonLog{},
+			errColumnCount,
+		},
+		{
+			"bad request",
+			[]string{`host,date,request,status`, `10.0.0.8,1549573866,GET,200`},
+			CommonLog{},
+			errRequestLine,
+		},
+		{
+			"unterminated quote",
+			[]string{`"10.0.0.9,"-","apache",1549573867,"GET / HTTP/1.0",200,1234`},
+			CommonLog{},
+			errNoMatch,
+		},
+	}
+	for _, c := range cases {
+		parse := newCSVParser()
+		for _, line := range c.lines[:len(c.lines)-1] {
+			if _, err := parse(line); err != errCSVHeader {
+				t.Errorf("csvParser failed. %s Want: header %q. Got: %v", c.name, line, err)
+			}
+		}
+		got, err := parse(c.lines[len(c.lines)-1])
+		if err != c.err || !sameLog(got, c.want) {
+			t.Errorf("csvParser failed. %s Want: %+v, %v. Got: %+v, %v", c.name, c.want, c.err, got, err)
+		}
+	}
+}
+
+// an empty extra is the same as none
+func sameLog(a CommonLog, b CommonLog) bool {
+	if len(a.extra) == 0 && len(b.extra) == 0 {
+		a.extra, b.extra = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestDetectFormat(t *testing.T) {
+	clf := `10.0.0.1 - apache [08/Feb/2019:21:11:00 +0000] "GET /api/user HTTP/1.0" 200 1234`
+	combined := clf + ` "http://example.com/" "curl/7.64"`
+	// nginx's combined log_format writes the same line as apache's
+	nginx := `10.0.0.1 - - [08/Feb/2019:21:11:00 +0000] "GET /api/user HTTP/1.1" 200 612 "-" "Mozilla/5.0"`
+	cases := []struct {
+		name  string
+		lines []string
+		want  string
+		ok    bool
+	}{
+		{"csv with header", []string{`"remotehost","rfc931","authuser","date","request","status","bytes"`, `"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`}, "csv", true},
+		{"csv without header", []string{`"10.0.0.2","-","apache",1549573860,"GET /api/user HTTP/1.0",200,1234`}, "csv", true},
+		{"clf", []string{clf, clf}, "clf", true},
+		{"combined", []string{combined, combined}, "combined", true},
+		{"nginx combined", []string{nginx}, "combined", true},
+		{"jsonl", []string{`{"host": "10.0.0.1", "epoch": 1549573860, "request": "GET /api/user HTTP/1.0", "status": 200}`}, "jsonl", true},
+		{"mostly clf", []string{clf, "garbage", clf}, "clf", true},
+		{"garbage", []string{"garbage", "more garbage,x", ""}, "", false},
+	}
+	for _, c := range cases {
+		got, ok := detectFormat(c.lines, "", false)
+		if got != c.want || ok != c.ok {
+			t.Errorf("detectFormat failed. %s Want: %q, %v. Got: %q, %v", c.name, c.want, c.ok, got, ok)
+		}
+	}
+}
+
+// with -clock wall a line's own timestamp, missing or bad, gives way to its arrival time
+func TestWallClockParser(t *testing.T) {
+	cases := []struct {
+		format string
+		line   string
+	}{
+		{"csv", `"10.0.0.2","-","apache",,"GET /api/user HTTP/1.0",200,1234`},
+		{"csv", `"10.0.0.2","-","apache",yesterday,"GET /api/user HTTP/1.0",200,1234`},
+		{"clf", `10.0.0.1 - apache [yesterday] "GET /api/user HTTP/1.0" 200 1234`},
+		{"jsonl", `{"host": "10.0.0.1", "request": "GET /api/user HTTP/1.0", "status": 200}`},
+	}
+	for _, c := range cases {
+		event, _ := newLineParser(c.format, "", "", false)
+		if _, err := event(c.line); err != errEpoch {
+			t.Errorf("newLineParser failed. %s %q Want: %v on event time. Got: %v", c.format, c.line, errEpoch, err)
+		}
+
+		wall, _ := newLineParser(c.format, "", "", true)
+		before := time.Now().Unix()
+		cl, err := wall(c.line)
+		epoch, _ := strconv.ParseInt(cl.epoch, 10, 64)
+		if err != nil || epoch < before || epoch > time.Now().Unix() {
+			t.Errorf("newLineParser failed. %s %q Want: the arrival time. Got: %q, %v", c.format, c.line, cl.epoch, err)
+		}
+	}
+
+	// the rest of the line is still checked
+	wall, _ := newLineParser("csv", "", "", true)
+	if _, err := wall(`"10.0.0.2","-","apache",,"GET /api/user HTTP/1.0",999,1234`); err != errStatus {
+		t.Errorf("newLineParser failed. Want: %v. Got: %v", errStatus, err)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	nested := "host=client.ip,epoch=ts,status=response.status,request=http.request"
+	cases := []struct {
+		name    string
+		jsonMap string
+		line    string
+		want    CommonLog
+		err     error
+	}{
+		{
+			"numeric epoch",
+			"",
+			`{"host": "10.0.0.1", "epoch": 1549573860, "request": "GET /api/user HTTP/1.0", "status": 200, "bytes": 1234}`,
+			CommonLog{host: "10.0.0.1", epoch: "1549573860", requestMethod: "GET", requestResource: "/api/user", requestProtocol: "HTTP/1.0", statusCode: "200", bytes: "1234"},
+			nil,
+		},
+		{
+			"rfc3339 epoch",
+			"",
+			`{"host": "10.0.0.1", "epoch": "2019-02-07T22:11:00+01:00", "request": "GET /api/user HTTP/1.0", "status": "200"}`,
+			CommonLog{host: "10.0.0.1", epoch: "1549573860", requestMethod: "GET", requestResource: "/api/user", requestProtocol: "HTTP/1.0", statusCode: "200"},
+			nil,
+		},
+		{
+			"nested jsonmap paths",
+			nested,
+			`{"client": {"ip": "10.0.0.3"}, "ts": 1549573861, "http": {"request": "POST /report HTTP/1.1"}, "response": {"status": 503}}`,
+			CommonLog{host: "10.0.0.3", epoch: "1549573861", requestMethod: "POST", requestResource: "/report", requestProtocol: "HTTP/1.1", statusCode: "503"},
+			nil,
+		},
+		{
+			"split request",
+			"",
+			`{"epoch": 1549573862, "method": "PUT", "resource": "/api/help", "protocol": "HTTP/2.0", "status": 201}`,
+			CommonLog{epoch: "1549573862", requestMethod: "PUT", requestResource: "/api/help", requestProtocol: "HTTP/2.0", statusCode: "201"},
+			nil,
+		},
+		{
+			"request over method, resource and protocol",
+			"",
+			`{"epoch": 1549573863, "request": "GET /a HTTP/1.0", "method": "PUT", "resource": "/b", "protocol": "HTTP/2.0", "status": 200}`,
+			CommonLog{epoch: "1549573863", requestMethod: "GET", requestResource: "/a", requestProtocol: "HTTP/1.0", statusCode: "200"},
+			nil,
+		},
+		{
+			"bad timestamp",
+			"",
+			`{"epoch": "yesterday", "request": "GET /a HTTP/1.0", "status": 200}`,
+			CommonLog{requestMethod: "GET", requestResource: "/a", requestProtocol: "HTTP/1.0", statusCode: "200"},
+			nil,
+		},
+		{"bad request", "", `{"epoch": 1549573864, "request": "GET", "status": 200}`, CommonLog{}, errRequestLine},
+		{"no request", "", `{"epoch": 1549573864, "status": 200}`, CommonLog{}, errRequestLine},
+		{"not json", "", `10.0.0.1 - - [07/Feb/2019:21:11:00 +0000] "GET / HTTP/1.0" 200 1`, CommonLog{}, errNoMatch},
+	}
+	for _, c := range cases {
+		parse, err := newJSONParser(c.jsonMap)
+		if err != nil {
+			t.Fatalf("newJSONParser failed. %s Got: %s", c.name, err)
+		}
+		got, err := parse(c.line)
+		if err != c.err || !sameLog(got, c.want) {
+			t.Errorf("jsonParser failed. %s Want: %+v, %v. Got: %+v, %v", c.name, c.want, c.err, got, err)
+		}
+	}
+
+	for _, jsonMap := range []string{"color=c", "host"} {
+		if _, err := newJSONParser(jsonMap); err == nil {
+			t.Errorf("newJSONParser failed. Want: an error for -jsonmap %q", jsonMap)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"github.com/NotHere1/monitor/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a named alert rule. e.g. hits of section user with status 5xx over 60 seconds > 50
+type alertRule struct {
+	name         string
+	metric       string            // hits, rate in hits per second, or ratio of the status filter
+	filter       map[string]string // section, method, status (500 or 5xx) and host a line must match
+	windowSecond int
+	comparison   string // >, >=, < or <=
+	threshold    float64
+	severity     string
+	minVolume    int // ratio only. fewer requests in the window neither fire nor resolve
+
+	// a rule by a filter field, e.g. section, has an alert lifecycle of its own for each value
+	// thresholds of the values. * for those not listed. values without either are not alerted on
+	by         string
+	thresholds map[string]float64
+
+	// hysteresis. the threshold must be breached for pendingSecond before the alert fires
+	// and it resolves once the value is past recovery, the threshold unless hasRecovery
+	// when it crossed the threshold flapCount times in flapWindowSecond
+	// it only resolves after staying recovered for flapWindowSecond
+	pendingSecond    int
+	recovery         float64
+	hasRecovery      bool
+	flapCount        int
+	flapWindowSecond int
+}
+
+var ruleMetrics = map[string]bool{"hits": true, "rate": true, "ratio": true}
+var ruleComparisons = map[string]bool{">": true, ">=": true, "<": true, "<=": true}
+var ruleFilters = []string{"section", "method", "status", "host"}
+
+// parse a -rule. comma separated key=value pairs
+// e.g. name=user errors,section=user,status=5xx,window=60,op=>,threshold=50,severity=critical
+// section is utils.ParseSection of the request, as in the window stats. /api/user is user
+// metric defaults to hits, window to windowSecond, op to > and severity to warning
+// by=section applies the threshold to each section on its own
+// for=30 fires once breached for 30 seconds, recovery=80 resolves below 80 instead of the threshold
+// flap=4,flap-window=60 holds an alert that crossed the threshold 4 times in 60 seconds open until recovered for 60
+// a ratio rule is the share of the requests matching its other filters that match status too
+// e.g. name=5xx rate,metric=ratio,status=5xx,threshold=0.05,min=20
+func parseRule(spec string, windowSecond int) (alertRule, error) {
+	kvs, err := utils.ParseKeyValues(spec)
+	if err != nil {
+		return alertRule{}, err
+	}
+	rule := alertRule{name: "", metric: "hits", filter: make(map[string]string), windowSecond: windowSecond, comparison: ">", severity: "warning"}
+	hasThreshold := false
+	for k, v := range kvs {
+		switch k {
+		case "name":
+			rule.name = v
+		case "metric":
+			rule.metric = v
+		case "section", "method", "status", "host":
+			rule.filter[k] = v
+		case "window":
+			if rule.windowSecond, err = strconv.Atoi(v); err != nil || rule.windowSecond <= 0 {
+				return alertRule{}, fmt.Errorf("bad window: %q", v)
+			}
+		case "op":
+			rule.comparison = v
+		case "threshold":
+			if rule.threshold, err = strconv.ParseFloat(v, 64); err != nil {
+				return alertRule{}, fmt.Errorf("bad threshold: %q", v)
+			}
+			hasThreshold = true
+		case "severity":
+			rule.severity = v
+		case "by":
+			rule.by = v
+		case "for":
+			if rule.pendingSecond, err = strconv.Atoi(v); err != nil || rule.pendingSecond < 0 {
+				return alertRule{}, fmt.Errorf("bad for: %q", v)
+			}
+		case "recovery":
+			if rule.recovery, err = strconv.ParseFloat(v, 64); err != nil {
+				return alertRule{}, fmt.Errorf("bad recovery: %q", v)
+			}
+			rule.hasRecovery = true
+		case "flap":
+			if rule.flapCount, err = strconv.Atoi(v); err != nil || rule.flapCount < 0 {
+				return alertRule{}, fmt.Errorf("bad flap: %q", v)
+			}
+		case "flap-window":
+			if rule.flapWindowSecond, err = strconv.Atoi(v); err != nil || rule.flapWindowSecond < 0 {
+				return alertRule{}, fmt.Errorf("bad flap-window: %q", v)
+			}
+		case "min":
+			if rule.minVolume, err = strconv.Atoi(v); err != nil || rule.minVolume < 0 {
+				return alertRule{}, fmt.Errorf("bad min: %q", v)
+			}
+		default:
+			return alertRule{}, fmt.Errorf("unknown rule key %q", k)
+		}
+	}
+	if rule.name == "" {
+		return alertRule{}, fmt.Errorf("rule has no name: %q", spec)
+	}
+	if !ruleMetrics[rule.metric] {
+		return alertRule{}, fmt.Errorf("rule %s: unknown metric %q", rule.name, rule.metric)
+	}
+	if !ruleComparisons[rule.comparison] {
+		return alertRule{}, fmt.Errorf("rule %s: unknown op %q", rule.name, rule.comparison)
+	}
+	if rule.metric == "ratio" && rule.filter["status"] == "" {
+		return alertRule{}, fmt.Errorf("rule %s: ratio needs a status", rule.name)
+	}
+	if !hasThreshold {
+		return alertRule{}, fmt.Errorf("rule %s has no threshold", rule.name)
+	}
+	if rule.hasRecovery && rule.by != "" {
+		return alertRule{}, fmt.Errorf("rule %s: recovery is one value, it cannot be by %s", rule.name, rule.by)
+	}
+	if err := rule.checkHysteresis(); err != nil {
+		return alertRule{}, err
+	}
+	if rule.by != "" {
+		if _, ok := rule.filter[rule.by]; ok || !isRuleFilter(rule.by) {
+			return alertRule{}, fmt.Errorf("rule %s: cannot be by %q", rule.name, rule.by)
+		}
+		rule.thresholds = map[string]float64{"*": rule.threshold}
+	}
+	return rule, nil
+}
+
+// repeatable -rule flag
+type ruleFlags []string
+
+func (r *ruleFlags) String() string {
+	return strings.Join(*r, " ")
+}
+
+func (r *ruleFlags) Set(spec string) error {
+	*r = append(*r, spec)
+	return nil
+}
+
+// recovery must be on the safe side of the threshold, and a flap count needs a window to count in
+func (rule alertRule) checkHysteresis() error {
+	if rule.hasRecovery {
+		above := rule.comparison == ">" || rule.comparison == ">="
+		if (above && rule.recovery > rule.threshold) || (!above && rule.recovery < rule.threshold) {
+			return fmt.Errorf("rule %s: recovery %s must not be past the threshold", rule.name, strconv.FormatFloat(rule.recovery, 'f', -1, 64))
+		}
+	}
+	if rule.flapCount > 0 && rule.flapWindowSecond <= 0 {
+		return fmt.Errorf("rule %s: flap needs a flap-window", rule.name)
+	}
+	return nil
+}
+
+func isRuleFilter(k string) bool {
+	for _, filter := range ruleFilters {
+		if k == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// -section-thresholds. hits of each section over windowSecond
+// e.g. report=20,help=200,*=100
+func sectionThresholdRule(spec string, windowSecond int) (alertRule, error) {
+	kvs, err := utils.ParseKeyValues(spec)
+	if err != nil {
+		return alertRule{}, err
+	}
+	thresholds := make(map[string]float64)
+	for section, v := range kvs {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return alertRule{}, fmt.Errorf("bad threshold for %s: %q", section, v)
+		}
+		thresholds[section] = threshold
+	}
+	return alertRule{name: "High traffic", metric: "hits", filter: make(map[string]string), windowSecond: windowSecond, comparison: ">", severity: "warning", by: "section", thresholds: thresholds}, nil
+}
+
+func (rule alertRule) String() string {
+	var filters []string
+	for _, k := range ruleFilters {
+		if v, ok := rule.filter[k]; ok {
+			filters = append(filters, k+"="+v)
+		}
+	}
+	metric := rule.metric
+	if len(filters) > 0 {
+		metric += " of " + strings.Join(filters, " ")
+	}
+	if rule.minVolume > 0 {
+		metric += fmt.Sprintf(" (min %d requests)", rule.minVolume)
+	}
+	threshold := strconv.FormatFloat(rule.threshold, 'f', -1, 64)
+	if rule.by != "" {
+		metric += " per " + rule.by
+		values := make([]string, 0, len(rule.thresholds))
+		for v := range rule.thresholds {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for i, v := range values {
+			values[i] = v + "=" + strconv.FormatFloat(rule.thresholds[v], 'f', -1, 64)
+		}
+		threshold = strings.Join(values, " ")
+	}
+	var hold string
+	if rule.pendingSecond > 0 {
+		hold += fmt.Sprintf(", for %d second", rule.pendingSecond)
+	}
+	if rule.hasRecovery {
+		hold += ", recovery " + strconv.FormatFloat(rule.recovery, 'f', -1, 64)
+	}
+	if rule.flapCount > 0 {
+		hold += fmt.Sprintf(", flap %d in %d second", rule.flapCount, rule.flapWindowSecond)
+	}
+	return fmt.Sprintf("%s - %s %s %s for past %d second%s, %s", rule.name, metric, rule.comparison, threshold, rule.windowSecond, hold, rule.severity)
+}
+
+// error rate rules of -error-rate. e.g. 5xx=0.05,4xx=0.2
+// 5xx is critical, 4xx warning
+func errorRateRules(spec string, windowSecond int, minVolume int) ([]alertRule, error) {
+	kvs, err := utils.ParseKeyValues(spec)
+	if err != nil {
+		return nil, err
+	}
+	for status := range kvs {
+		if status != "5xx" && status != "4xx" {
+			return nil, fmt.Errorf("unknown status class %q. 5xx or 4xx", status)
+		}
+	}
+	var rules []alertRule
+	for _, status := range []string{"5xx", "4xx"} {
+		v, ok := kvs[status]
+		if !ok {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("bad %s ratio: %q", status, v)
+		}
+		severity := "warning"
+		if status == "5xx" {
+			severity = "critical"
+		}
+		rules = append(rules, alertRule{name: status + " rate", metric: "ratio", filter: map[string]string{"status": status}, windowSecond: windowSecond, comparison: ">", threshold: threshold, severity: severity, minVolume: minVolume})
+	}
+	return rules, nil
+}
+
+// a line is counted by the rule when it matches every filter
+// a ratio rule counts the lines matching all but status as its volume
+func (rule alertRule) matches(log CommonLog) bool {
+	return rule.matchesExcept(log, "")
+}
+
+func (rule alertRule) matchesExcept(log CommonLog, except string) bool {
+	for k, v := range rule.filter {
+		if k == except {
+			continue
+		}
+		field := filterField(log, k)
+		// a status class. e.g. 5xx
+		if k == "status" && len(v) == 3 && strings.HasSuffix(strings.ToLower(v), "xx") && len(field) == 3 {
+			field = field[:1] + "xx"
+			v = strings.ToLower(v)
+		}
+		if field != v {
+			return false
+		}
+	}
+	return true
+}
+
+// the value of a line a rule filters on
+func filterField(log CommonLog, k string) string {
+	switch k {
+	case "section":
+		return utils.ParseSection(log.requestResource)
+	case "method":
+		return log.requestMethod
+	case "status":
+		return log.statusCode
+	case "host":
+		return log.host
+	}
+	return ""
+}
+
+// the states of a rule. one, or with by one for each value seen, made as they first appear
+type ruleGroup struct {
+	rule   alertRule
+	states map[string]*ruleState
+}
+
+func newRuleGroup(rule alertRule) *ruleGroup {
+	return &ruleGroup{rule, make(map[string]*ruleState)}
+}
+
+// state the line is counted in, and whether it was just made
+// nil if the line is by a value without a threshold
+// states by value are dropped by evict once idle, and made again when the value comes back
+func (g *ruleGroup) state(log CommonLog) (*ruleState, bool) {
+	value := ""
+	if g.rule.by != "" {
+		value = filterField(log, g.rule.by)
+	}
+	if state, ok := g.states[value]; ok {
+		return state, false
+	}
+
+	rule := g.rule
+	if rule.by != "" {
+		threshold, ok := rule.thresholds[value]
+		if !ok {
+			if threshold, ok = rule.thresholds["*"]; !ok {
+				return nil, false
+			}
+		}
+		rule.name = rule.name + " " + value
+		rule.threshold = threshold
+		rule.filter = map[string]string{rule.by: value}
+		for k, v := range g.rule.filter {
+			rule.filter[k] = v
+		}
+		rule.by, rule.thresholds = "", nil
+	}
+	state := newRuleState(rule)
+	g.states[value] = state
+	return state, true
+}
+
+// drop the idle states of a by rule into evicted
+// with * every value gets a state, and a path with ids in it has no end of values
+func (g *ruleGroup) evict(evicted map[*ruleState]bool) {
+	if g.rule.by == "" {
+		return
+	}
+	for value, state := range g.states {
+		if state.idle() {
+			delete(g.states, value)
+			evicted[state] = true
+		}
+	}
+}
+
+// firing and resolved state of one rule
+// its ring counts the matching hits of each second of event time
+// and, for a ratio, volume the requests it is a share of
+type ruleState struct {
+	rule           alertRule
+	ring           *utils.HitRing
+	volume         *utils.HitRing
+	firing         bool
+	begin          int
+	breached       bool  // at the last evaluated second
+	pendingSince   int   // second the threshold was breached from while not firing. -1 if not
+	recoveredSince int   // second the value recovered from while firing. -1 if not
+	crossings      []int // seconds the threshold was crossed at, within flapWindowSecond
+}
+
+func newRuleState(rule alertRule) *ruleState {
+	var volume *utils.HitRing
+	if rule.metric == "ratio" {
+		volume = utils.NewHitRing(rule.windowSecond)
+	}
+	return &ruleState{rule, utils.NewHitRing(rule.windowSecond), volume, false, 0, false, -1, -1, nil}
+}
+
+// an idle state is as good as a new one. nothing in its window, and not firing, pending or flapping
+func (s *ruleState) idle() bool {
+	return !s.firing && !s.breached && s.pendingSince < 0 && len(s.crossings) == 0 && s.ring.Total() == 0 && (s.volume == nil || s.volume.Total() == 0)
+}
+
+func (s *ruleState) value() float64 {
+	hits := float64(s.ring.Total())
+	switch s.rule.metric {
+	case "rate":
+		return hits / float64(s.rule.windowSecond)
+	case "ratio":
+		if s.volume.Total() == 0 {
+			return 0
+		}
+		return hits / float64(s.volume.Total())
+	}
+	return hits
+}
+
+// fire when the value compares to the threshold as the rule says, for pendingSecond
+// and resolve only once it is strictly past recovery on the other side, so a value resting on it keeps the state
+// a flapping alert stays open until it has been recovered for flapWindowSecond
+func (s *ruleState) evaluate(second int) (Alert, bool) {
+	if s.volume != nil && s.volume.Total() < s.rule.minVolume {
+		// too quiet for the ratio to mean anything
+		return Alert{}, false
+	}
+	value := s.value()
+	recovery := s.rule.threshold
+	if s.rule.hasRecovery {
+		recovery = s.rule.recovery
+	}
+	var breached, recovered bool
+	switch s.rule.comparison {
+	case ">":
+		breached, recovered = value > s.rule.threshold, value < recovery
+	case ">=":
+		breached, recovered = value >= s.rule.threshold, value < recovery
+	case "<":
+		breached, recovered = value < s.rule.threshold, value > recovery
+	case "<=":
+		breached, recovered = value <= s.rule.threshold, value > recovery
+	}
+
+	if s.rule.flapCount > 0 {
+		if breached != s.breached {
+			s.crossings = append(s.crossings, second)
+		}
+		for len(s.crossings) > 0 && s.crossings[0] <= second-s.rule.flapWindowSecond {
+			s.crossings = s.crossings[1:]
+		}
+	}
+	s.breached = breached
+
+	if !s.firing {
+		if !breached {
+			s.pendingSince = -1
+			return Alert{}, false
+		}
+		if s.pendingSince < 0 {
+			s.pendingSince = second
+		}
+		if second-s.pendingSince < s.rule.pendingSecond {
+			return Alert{}, false
+		}
+		s.firing = true
+		s.begin = second
+		s.pendingSince = -1
+		return Alert{s.rule, value, s.begin, 0, false}, true
+	}
+
+	if !recovered {
+		s.recoveredSince = -1
+		return Alert{}, false
+	}
+	if s.recoveredSince < 0 {
+		s.recoveredSince = second
+	}
+	flapping := s.rule.flapCount > 0 && len(s.crossings) >= s.rule.flapCount
+	if flapping && second-s.recoveredSince < s.rule.flapWindowSecond {
+		return Alert{}, false
+	}
+	s.firing = false
+	s.recoveredSince = -1
+	return Alert{s.rule, value, s.begin, second, false}, true
+}
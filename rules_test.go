@@ -0,0 +1,246 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := parseRule("name=user errors,section=user,status=5xx,window=60,op=>=,threshold=50,severity=critical", 10)
+	if err != nil {
+		t.Fatalf("parseRule failed. Got: %s", err)
+	}
+	if rule.name != "user errors" || rule.metric != "hits" || rule.filter["section"] != "user" || rule.filter["status"] != "5xx" || rule.windowSecond != 60 || rule.comparison != ">=" || rule.threshold != 50 || rule.severity != "critical" {
+		t.Errorf("parseRule failed. Got: %+v", rule)
+	}
+
+	rule, err = parseRule("name=t,threshold=5", 10)
+	if err != nil || rule.metric != "hits" || rule.windowSecond != 10 || rule.comparison != ">" || rule.severity != "warning" {
+		t.Errorf("parseRule failed. Want: the defaults. Got: %+v, %v", rule, err)
+	}
+	rule, err = parseRule("name=t,metric=ratio,status=5xx,threshold=0.05,min=20", 10)
+	if err != nil || rule.metric != "ratio" || rule.minVolume != 20 {
+		t.Errorf("parseRule failed. Want: a ratio of at least 20 requests. Got: %+v, %v", rule, err)
+	}
+	rule, err = parseRule("name=t,by=section,threshold=5", 10)
+	if err != nil || rule.by != "section" || rule.thresholds["*"] != 5 {
+		t.Errorf("parseRule failed. Want: by section, * at 5. Got: %+v, %v", rule, err)
+	}
+	rule, err = parseRule("name=t,threshold=50,for=30,recovery=40,flap=4,flap-window=120", 10)
+	if err != nil || rule.pendingSecond != 30 || !rule.hasRecovery || rule.recovery != 40 || rule.flapCount != 4 || rule.flapWindowSecond != 120 {
+		t.Errorf("parseRule failed. Want: for 30, recovery 40, flap 4 in 120. Got: %+v, %v", rule, err)
+	}
+
+	bad := []string{
+		"threshold=5",
+		"name=t",
+		"name=t,threshold=x",
+		"name=t,threshold=5,window=0",
+		"name=t,threshold=5,metric=count",
+		"name=t,threshold=5,op=!=",
+		"name=t,threshold=5,color=red",
+		"name=t,threshold=0.5,metric=ratio",
+		"name=t,threshold=0.5,metric=ratio,status=5xx,min=-1",
+		"name=t,threshold=5,by=color",
+		"name=t,threshold=5,section=user,by=section",
+		"name=t,threshold=5,recovery=6",
+		"name=t,threshold=5,op=<,recovery=4",
+		"name=t,threshold=5,flap=3",
+		"name=t,threshold=5,for=-1",
+		"name=t,threshold=5,recovery=4,by=section",
+	}
+	for _, spec := range bad {
+		if _, err := parseRule(spec, 10); err == nil {
+			t.Errorf("parseRule failed. Want: an error for %q", spec)
+		}
+	}
+}
+
+func TestErrorRateRules(t *testing.T) {
+	rules, err := errorRateRules("4xx=0.2,5xx=0.05", 120, 20)
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("errorRateRules failed. Want: 2 rules. Got: %v, %v", rules, err)
+	}
+	want := []struct {
+		name      string
+		status    string
+		threshold float64
+		severity  string
+	}{
+		{"5xx rate", "5xx", 0.05, "critical"},
+		{"4xx rate", "4xx", 0.2, "warning"},
+	}
+	for i, w := range want {
+		rule := rules[i]
+		if rule.name != w.name || rule.metric != "ratio" || rule.filter["status"] != w.status || rule.threshold != w.threshold || rule.severity != w.severity || rule.windowSecond != 120 || rule.comparison != ">" || rule.minVolume != 20 {
+			t.Errorf("errorRateRules failed. Want: %v. Got: %+v", w, rule)
+		}
+	}
+
+	// the ratio is only evaluated over at least min requests
+	state := newRuleState(rules[0])
+	state.ring.Advance(1000)
+	state.volume.Advance(1000)
+	state.ring.Add(1000, 10)
+	state.volume.Add(1000, 10)
+	if _, ok := state.evaluate(1000); ok {
+		t.Errorf("errorRateRules failed. Want: no alert under 20 requests")
+	}
+	state.volume.Add(1000, 10)
+	if alert, ok := state.evaluate(1000); !ok || alert.value != 0.5 {
+		t.Errorf("errorRateRules failed. Want: an alert at 0.5 of 20 requests. Got: %+v, %v", alert, ok)
+	}
+
+	for _, spec := range []string{"3xx=0.1", "5xx=1.5", "4xx=-0.1", "5xx=x"} {
+		if _, err := errorRateRules(spec, 120, 20); err == nil {
+			t.Errorf("errorRateRules failed. Want: an error for %q", spec)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	log := CommonLog{host: "10.0.0.1", requestMethod: "POST", requestResource: "/api/user", statusCode: "503"}
+	cases := []struct {
+		filter map[string]string
+		except string
+		want   bool
+	}{
+		{map[string]string{"status": "5xx"}, "", true},
+		{map[string]string{"status": "5XX"}, "", true},
+		{map[string]string{"status": "503"}, "", true},
+		{map[string]string{"status": "500"}, "", false},
+		{map[string]string{"status": "4xx"}, "", false},
+		{map[string]string{"status": "4xx"}, "status", true},
+		{map[string]string{"status": "4xx", "method": "GET"}, "status", false},
+		{map[string]string{"section": "user", "method": "POST", "host": "10.0.0.1"}, "", true},
+		{map[string]string{"section": "api"}, "", false},
+	}
+	for _, c := range cases {
+		rule := alertRule{name: "t", filter: c.filter}
+		if got := rule.matchesExcept(log, c.except); got != c.want {
+			t.Errorf("matchesExcept failed. %v except %q Want: %v. Got: %v", c.filter, c.except, c.want, got)
+		}
+	}
+}
+
+func TestRuleGroupState(t *testing.T) {
+	rule, err := sectionThresholdRule("report=20,*=100", 10)
+	if err != nil {
+		t.Fatalf("sectionThresholdRule failed. Got: %s", err)
+	}
+	group := newRuleGroup(rule)
+
+	report, made := group.state(CommonLog{requestResource: "/report"})
+	if report == nil || !made || report.rule.threshold != 20 || report.rule.name != "High traffic report" || report.rule.filter["section"] != "report" {
+		t.Fatalf("ruleGroup.state failed. Want: a new report state at 20. Got: %+v, %v", report, made)
+	}
+	if again, made := group.state(CommonLog{requestMethod: "POST", requestResource: "/report"}); again != report || made {
+		t.Errorf("ruleGroup.state failed. Want: the same report state. Got: %+v, %v", again, made)
+	}
+	if user, made := group.state(CommonLog{requestResource: "/api/user"}); user == nil || !made || user.rule.threshold != 100 {
+		t.Errorf("ruleGroup.state failed. Want: a user state at the * threshold 100. Got: %+v, %v", user, made)
+	}
+
+	// without * only the listed sections are alerted on
+	rule, _ = sectionThresholdRule("report=20", 10)
+	group = newRuleGroup(rule)
+	if user, made := group.state(CommonLog{requestResource: "/api/user"}); user != nil || made {
+		t.Errorf("ruleGroup.state failed. Want: no state for user. Got: %+v, %v", user, made)
+	}
+	if report, _ := group.state(CommonLog{requestResource: "/report"}); report == nil || report.rule.threshold != 20 {
+		t.Errorf("ruleGroup.state failed. Want: a report state at 20. Got: %+v", report)
+	}
+}
+
+// idle states under * are dropped, busy and firing ones kept
+func TestRuleGroupEvict(t *testing.T) {
+	rule, _ := sectionThresholdRule("*=5", 10)
+	group := newRuleGroup(rule)
+	states := make(map[string]*ruleState)
+	for _, id := range []string{"1", "2", "3"} {
+		states[id], _ = group.state(CommonLog{requestResource: "/item/" + id})
+		states[id].ring.Advance(1000)
+	}
+	states["1"].ring.Add(1000, 1)
+	states["2"].ring.Add(1000, 6)
+	for _, state := range states {
+		state.evaluate(1000)
+	}
+	// a second later the hits of 2 are gone, but its alert is still to resolve
+	states["2"].ring.Advance(1010)
+
+	evicted := make(map[*ruleState]bool)
+	group.evict(evicted)
+	if len(evicted) != 1 || !evicted[states["3"]] || len(group.states) != 2 {
+		t.Errorf("ruleGroup.evict failed. Want: 3 evicted. Got: %v of %v", evicted, group.states)
+	}
+	if state, made := group.state(CommonLog{requestResource: "/item/3"}); state == states["3"] || !made {
+		t.Errorf("ruleGroup.state failed. Want: a new state for 3. Got: %+v, %v", state, made)
+	}
+
+	// a rule without by keeps its one state
+	rule, _ = parseRule("name=t,threshold=5", 10)
+	group = newRuleGroup(rule)
+	group.state(CommonLog{requestResource: "/item/1"})
+	group.evict(evicted)
+	if len(group.states) != 1 {
+		t.Errorf("ruleGroup.evict failed. Want: the state of a rule without by kept. Got: %v", group.states)
+	}
+}
+
+// feed a rule state the hits, and for a ratio the volume, of consecutive seconds from 1000
+// and collect the seconds it fired and resolved at
+func runRule(t *testing.T, spec string, hits []int, volumes []int) ([]int, []int) {
+	rule, err := parseRule(spec, 10)
+	if err != nil {
+		t.Fatalf("parseRule failed. %q Got: %s", spec, err)
+	}
+	state := newRuleState(rule)
+	var fires, resolves []int
+	for i, n := range hits {
+		second := 1000 + i
+		state.ring.Advance(second)
+		state.ring.Add(second, n)
+		if state.volume != nil {
+			state.volume.Advance(second)
+			state.volume.Add(second, volumes[i])
+		}
+		if alert, ok := state.evaluate(second); ok {
+			if alert.end == 0 {
+				fires = append(fires, second)
+			} else {
+				resolves = append(resolves, alert.end)
+			}
+		}
+	}
+	return fires, resolves
+}
+
+func TestRuleStateEvaluate(t *testing.T) {
+	cases := []struct {
+		name     string
+		spec     string
+		hits     []int
+		volumes  []int
+		fires    []int
+		resolves []int
+	}{
+		{"threshold", "name=t,window=1,threshold=5", []int{0, 6, 6, 0, 6}, nil, []int{1001, 1004}, []int{1003}},
+		{"resting on the threshold", "name=t,window=1,threshold=5", []int{6, 5, 5, 4}, nil, []int{1000}, []int{1003}},
+		{"below", "name=t,window=1,op=<,threshold=2", []int{5, 1, 1, 3}, nil, []int{1001}, []int{1003}},
+		{"over the window", "name=t,window=3,threshold=5", []int{2, 2, 2, 2, 0, 0}, nil, []int{1002}, []int{1004}},
+		{"rate", "name=t,metric=rate,window=2,threshold=2", []int{2, 3, 2, 0}, nil, []int{1001}, []int{1003}},
+		{"for", "name=t,window=1,threshold=5,for=2", []int{6, 6, 0, 6, 6, 6, 0}, nil, []int{1005}, []int{1006}},
+		{"recovery", "name=t,window=1,threshold=5,recovery=3", []int{6, 4, 4, 2, 6}, nil, []int{1000, 1004}, []int{1003}},
+		{"without flap", "name=t,window=1,threshold=5", []int{6, 0, 6, 0, 0, 0, 0}, nil, []int{1000, 1002}, []int{1001, 1003}},
+		{"flap holds open", "name=t,window=1,threshold=5,flap=2,flap-window=3", []int{6, 0, 6, 0, 0, 0, 0}, nil, []int{1000}, []int{1005}},
+		{"ratio", "name=t,metric=ratio,status=5xx,window=1,threshold=0.5", []int{2, 3, 1}, []int{2, 4, 4}, []int{1000}, []int{1002}},
+		{"ratio min volume", "name=t,metric=ratio,status=5xx,window=1,threshold=0.5,min=4", []int{2, 3, 1}, []int{2, 4, 4}, []int{1001}, []int{1002}},
+		{"ratio min volume holds", "name=t,metric=ratio,status=5xx,window=1,threshold=0.5,min=4", []int{3, 0, 1}, []int{4, 1, 4}, []int{1000}, []int{1002}},
+	}
+	for _, c := range cases {
+		fires, resolves := runRule(t, c.spec, c.hits, c.volumes)
+		if !sameInts(fires, c.fires) || !sameInts(resolves, c.resolves) {
+			t.Errorf("evaluate failed. %s Want: fires %v, resolves %v. Got: fires %v, resolves %v", c.name, c.fires, c.resolves, fires, resolves)
+		}
+	}
+}
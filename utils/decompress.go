@@ -0,0 +1,94 @@
+package utils
+
+import "bufio"
+import "bytes"
+import "compress/bzip2"
+import "compress/gzip"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import str "strings"
+
+import "github.com/klauspost/compress/zstd"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+var bzip2Magic = []byte("BZh")
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// extension -> compression expected from the magic bytes
+var compressedExtensions = map[string]string{
+	".gz":   "gzip",
+	".gzip": "gzip",
+	".bz2":  "bzip2",
+	".zst":  "zstd",
+	".zstd": "zstd",
+}
+
+func IsCompressedPath(path string) bool {
+	_, ok := compressedExtensions[str.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// OpenDecompressed opens path and transparently decompresses gzip, bzip2 and zstd files.
+// the compression is detected from the magic bytes. anything else is read as plain text,
+// unless the extension promised a compressed file
+func OpenDecompressed(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	want, ok := compressedExtensions[str.ToLower(filepath.Ext(path))]
+	if ok && r.compression != want {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a %s file", path, want)
+	}
+	return &decompressedFile{r, f}, nil
+}
+
+type decompressedReader struct {
+	io.Reader
+	compression string
+	close       func()
+}
+
+type decompressedFile struct {
+	*decompressedReader
+	file *os.File
+}
+
+func (f *decompressedFile) Close() error {
+	if f.close != nil {
+		f.close()
+	}
+	return f.file.Close()
+}
+
+// decompress wraps r in the decompressor its magic bytes call for
+func decompress(r io.Reader) (*decompressedReader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedReader{zr, "gzip", nil}, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return &decompressedReader{bzip2.NewReader(br), "bzip2", nil}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedReader{zr, "zstd", zr.Close}, nil
+	}
+	return &decompressedReader{br, "", nil}, nil
+}
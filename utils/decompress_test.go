@@ -0,0 +1,69 @@
+package utils
+
+import "bytes"
+import "compress/gzip"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+import "github.com/klauspost/compress/zstd"
+
+const plainLines = "line 1\nline 2\n"
+
+// bzip2 of plainLines. the standard library has no bzip2 writer
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x31, 0x88, 0x21, 0x68, 0x00, 0x00,
+	0x05, 0x59, 0x00, 0x00, 0x10, 0x40, 0x00, 0x30, 0x00, 0x02, 0x25, 0x20, 0x00, 0x31, 0x0c, 0x08,
+	0x12, 0x86, 0x46, 0x89, 0x31, 0x90, 0x87, 0x10, 0xf1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x03, 0x18,
+	0x82, 0x16, 0x80,
+}
+
+func TestOpenDecompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decompress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(plainLines))
+	gw.Close()
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := zw.EncodeAll([]byte(plainLines), nil)
+
+	files := map[string][]byte{
+		"access.log":       []byte(plainLines),
+		"access.log.1.gz":  gz.Bytes(),
+		"access.log.2.bz2": bzip2Lines,
+		"access.log.3.zst": zst,
+		"access.log.4":     gz.Bytes(), // detected by magic bytes alone
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := OpenDecompressed(path)
+		if err != nil {
+			t.Errorf("OpenDecompressed failed for %s, got: %s", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != plainLines {
+			t.Errorf("OpenDecompressed failed for %s. Want: %q. Got: %q (%v)", name, plainLines, got, err)
+		}
+	}
+
+	path := filepath.Join(dir, "plain.gz")
+	ioutil.WriteFile(path, []byte(plainLines), 0644)
+	if _, err := OpenDecompressed(path); err == nil {
+		t.Errorf("OpenDecompressed failed. Want error for plain text named .gz")
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import "io"
+import "os"
+import "time"
+
+// FollowReader reads a file and keeps waiting for appended data, like tail -F.
+// when logrotate renames the file away (different inode) the old file is read to its end
+// before the new one at path is opened. a truncated file (copytruncate) is read again from the start.
+type FollowReader struct {
+	path   string
+	file   *os.File
+	offset int64
+	poll   time.Duration
+}
+
+func NewFollowReader(path string, poll time.Duration) (*FollowReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FollowReader{path, f, 0, poll}, nil
+}
+
+// Read blocks until data is available. it only returns io.EOF once closed
+func (r *FollowReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			return 0, io.EOF
+		}
+		n, err := r.file.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		rotated, err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+		if !rotated {
+			time.Sleep(r.poll)
+		}
+	}
+}
+
+// at the end of the current file, check whether path was rotated or truncated
+func (r *FollowReader) rotate() (bool, error) {
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		// renamed away. wait for the new file to be created
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	cur, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(info, cur) {
+		// the writer may append to the old file until it reopens its log.
+		// only switch once that final read also comes back empty
+		if cur.Size() > r.offset {
+			return true, nil
+		}
+		f, err := os.Open(r.path)
+		if err != nil {
+			return false, err
+		}
+		r.file.Close()
+		r.file = f
+		r.offset = 0
+		return true, nil
+	}
+
+	if info.Size() < r.offset {
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.offset = 0
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *FollowReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,81 @@
+package utils
+
+import "bufio"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+func appendLines(t *testing.T, path string, lines string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	appendLines(t, path, "1\n2\n")
+
+	r, err := NewFollowReader(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case got := <-lines:
+				if got != w {
+					t.Fatalf("FollowReader failed. Want: %s. Got: %s", w, got)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("FollowReader failed. Want: %s. Got nothing", w)
+			}
+		}
+	}
+	expect("1", "2")
+
+	// appended
+	appendLines(t, path, "3\n")
+	expect("3")
+
+	// logrotate rename. the old file gets one last line after the rename
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path+".1", "4\n")
+	appendLines(t, path, "5\n")
+	expect("4", "5")
+
+	// copytruncate
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	appendLines(t, path, "6\n")
+	expect("6")
+
+	select {
+	case got := <-lines:
+		t.Errorf("FollowReader failed. Want no more lines. Got: %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
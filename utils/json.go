@@ -0,0 +1,60 @@
+package utils
+
+import "encoding/json"
+import "fmt"
+import "strconv"
+import str "strings"
+import "time"
+
+// ParseKeyValues parses a comma separated list of key=value pairs. e.g. host=client.ip,epoch=ts
+func ParseKeyValues(pairs string) (map[string]string, error) {
+	kvs := make(map[string]string)
+	for _, pair := range str.Split(pairs, ",") {
+		if str.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := str.SplitN(pair, "=", 2)
+		if len(kv) != 2 || str.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("expected key=value, got: %q", pair)
+		}
+		kvs[str.TrimSpace(kv[0])] = str.TrimSpace(kv[1])
+	}
+	return kvs, nil
+}
+
+// LookupJSONPath finds a dot separated key path in a decoded json object. e.g. http.status
+func LookupJSONPath(obj map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = obj
+	for _, key := range str.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// JSONString formats a decoded json scalar the way it was written
+func JSONString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// ParseJSONTime accepts numeric epochs (seconds, fractions allowed) or RFC3339 timestamps
+func ParseJSONTime(v interface{}) (time.Time, error) {
+	s := JSONString(v)
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(int64(sec), 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,50 @@
+package utils
+
+import "encoding/json"
+import "strings"
+import "testing"
+
+func TestParseKeyValues(t *testing.T) {
+	kvs, err := ParseKeyValues("host=client.ip, epoch=ts,status=http.status")
+	if err != nil {
+		t.Fatalf("ParseKeyValues failed, got: %s", err)
+	}
+	if len(kvs) != 3 || kvs["host"] != "client.ip" || kvs["epoch"] != "ts" || kvs["status"] != "http.status" {
+		t.Errorf("ParseKeyValues failed. Got: %v", kvs)
+	}
+	if _, err := ParseKeyValues("host"); err == nil {
+		t.Errorf("ParseKeyValues failed. Want error for missing =")
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(`{"client": {"ip": "10.0.0.2"}, "http": {"status": 200}, "ts": "2019-02-07T21:11:00Z"}`))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := LookupJSONPath(obj, "client.ip"); !ok || JSONString(v) != "10.0.0.2" {
+		t.Errorf("LookupJSONPath failed. Want: 10.0.0.2. Got: %v", v)
+	}
+	if v, ok := LookupJSONPath(obj, "http.status"); !ok || JSONString(v) != "200" {
+		t.Errorf("LookupJSONPath failed. Want: 200. Got: %v", v)
+	}
+	if _, ok := LookupJSONPath(obj, "client.ip.v4"); ok {
+		t.Errorf("LookupJSONPath failed. Want no match for client.ip.v4")
+	}
+}
+
+func TestParseJSONTime(t *testing.T) {
+	for _, v := range []interface{}{json.Number("1549573860"), json.Number("1549573860.25"), "1549573860", "2019-02-07T21:11:00Z", "2019-02-07T16:11:00-05:00"} {
+		tm, err := ParseJSONTime(v)
+		if err != nil {
+			t.Errorf("ParseJSONTime failed for %v, got: %s", v, err)
+		} else if tm.Unix() != 1549573860 {
+			t.Errorf("ParseJSONTime failed for %v. Want: 1549573860. Got: %d", v, tm.Unix())
+		}
+	}
+	if _, err := ParseJSONTime("yesterday"); err == nil {
+		t.Errorf("ParseJSONTime failed. Want error for yesterday")
+	}
+}
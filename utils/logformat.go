@@ -0,0 +1,182 @@
+package utils
+
+import "fmt"
+import "regexp"
+import str "strings"
+
+// quoted fields (request line, headers) may contain escaped quotes
+const quotedField = `((?:[^"\\]|\\.)*)`
+
+// LogFormat is an apache LogFormat directive string compiled into a regex.
+// each capture group fills the field named after its directive. e.g.
+// %h -> "h", %>s -> "s", %{Referer}i -> "{referer}i"
+type LogFormat struct {
+	re     *regexp.Regexp
+	fields []string
+}
+
+func CompileLogFormat(format string) (*LogFormat, error) {
+	var pattern str.Builder
+	var fields []string
+	pattern.WriteString("^")
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil, fmt.Errorf("logformat: trailing %% in %q", format)
+		}
+		if format[i] == '%' {
+			pattern.WriteString("%")
+			continue
+		}
+
+		// skip modifiers. e.g. %>s, %<u, %!200,304{Referer}i
+		for i < len(format) && str.IndexByte("<>!,0123456789", format[i]) >= 0 {
+			i++
+		}
+		name := ""
+		if i < len(format) && format[i] == '{' {
+			end := str.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("logformat: unclosed { in %q", format)
+			}
+			name = format[i : i+end+1]
+			i += end + 1
+		}
+		if i == len(format) {
+			return nil, fmt.Errorf("logformat: missing directive at end of %q", format)
+		}
+		directive := format[i]
+
+		field := string(directive)
+		if name != "" {
+			field = str.ToLower(name) + field
+		}
+		fields = append(fields, field)
+		pattern.WriteString(directivePattern(directive, name))
+	}
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	return &LogFormat{re, fields}, nil
+}
+
+func directivePattern(directive byte, name string) string {
+	switch directive {
+	case 't':
+		if name == "" {
+			return `\[([^\]]+)\]`
+		}
+		return quotedField
+	case 'r', 'i', 'o', 'e', 'n', 'C':
+		// request line, headers, env, notes and cookies may contain spaces
+		return quotedField
+	case 's':
+		return `(\d{3}|-)`
+	case 'b', 'B', 'D', 'T', 'I', 'O', 'S', 'k':
+		return `(\d+|-)`
+	}
+	return `(\S*)`
+}
+
+// Parse returns the directive fields of line, or false if it does not match the format
+func (lf *LogFormat) Parse(line string) (map[string]string, bool) {
+	m := lf.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(lf.fields))
+	for idx, field := range lf.fields {
+		fields[field] = m[idx+1]
+	}
+	return fields, true
+}
+
+// nginx variables that have an apache LogFormat directive equivalent
+// are given the directive's field name, so both formats fill CommonLog alike
+var nginxDirectives = map[string]string{
+	"remote_addr":     "h",
+	"remote_user":     "u",
+	"time_local":      "t",
+	"request":         "r",
+	"request_method":  "m",
+	"uri":             "U",
+	"server_protocol": "H",
+	"status":          "s",
+	"body_bytes_sent": "b",
+	"http_referer":    "{referer}i",
+	"http_user_agent": "{user-agent}i",
+}
+
+// CompileNginxFormat compiles an nginx log_format string. e.g.
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent
+// variables without an apache equivalent keep their nginx name. e.g. "request_time"
+func CompileNginxFormat(format string) (*LogFormat, error) {
+	var pattern str.Builder
+	var fields []string
+	pattern.WriteString("^")
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '$' {
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		i++
+		name := ""
+		if i < len(format) && format[i] == '{' {
+			end := str.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("log_format: unclosed { in %q", format)
+			}
+			name = format[i+1 : i+end]
+			i += end
+		} else {
+			start := i
+			for i < len(format) && isNginxVarChar(format[i]) {
+				i++
+			}
+			name = format[start:i]
+			i--
+		}
+		if name == "" {
+			return nil, fmt.Errorf("log_format: missing variable name in %q", format)
+		}
+
+		field := name
+		if directive, ok := nginxDirectives[name]; ok {
+			field = directive
+		}
+		fields = append(fields, field)
+		pattern.WriteString(nginxVariablePattern(name))
+	}
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	return &LogFormat{re, fields}, nil
+}
+
+func isNginxVarChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func nginxVariablePattern(name string) string {
+	switch {
+	case name == "time_local":
+		return `([^\]]+)`
+	case name == "request", str.HasPrefix(name, "http_"), str.HasPrefix(name, "cookie_"):
+		return quotedField
+	case name == "status":
+		return `(\d{3}|-)`
+	}
+	return `(\S*)`
+}
@@ -0,0 +1,75 @@
+package utils
+
+import "testing"
+
+func TestCompileLogFormat(t *testing.T) {
+	lf, err := CompileLogFormat(`%h %l %u %t "%r" %>s %b %D "%{X-Forwarded-For}i" %v`)
+	if err != nil {
+		t.Fatalf("CompileLogFormat failed, got: %s", err)
+	}
+	line := `10.0.0.2 - apache [07/Feb/2019:21:11:00 +0000] "GET /api/user?q=\"a b\" HTTP/1.0" 200 1234 5012 "10.1.1.1, 10.2.2.2" www.example.com`
+	fields, ok := lf.Parse(line)
+	if !ok {
+		t.Fatalf("LogFormat.Parse failed to match: %s", line)
+	}
+	want := map[string]string{
+		"h":                  "10.0.0.2",
+		"l":                  "-",
+		"u":                  "apache",
+		"t":                  "07/Feb/2019:21:11:00 +0000",
+		"r":                  `GET /api/user?q=\"a b\" HTTP/1.0`,
+		"s":                  "200",
+		"b":                  "1234",
+		"D":                  "5012",
+		"{x-forwarded-for}i": "10.1.1.1, 10.2.2.2",
+		"v":                  "www.example.com",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("LogFormat.Parse failed for %s. Want: %q. Got: %q", k, v, fields[k])
+		}
+	}
+	if _, ok := lf.Parse(`10.0.0.2 - apache 1549573860 "GET / HTTP/1.0" 200 1234`); ok {
+		t.Errorf("LogFormat.Parse failed. Want no match for missing [date]")
+	}
+}
+
+func TestCompileLogFormatErrors(t *testing.T) {
+	for _, format := range []string{`%h %`, `%{Referer i`, `%h %{Referer}`} {
+		if _, err := CompileLogFormat(format); err == nil {
+			t.Errorf("CompileLogFormat failed. Want error for %q", format)
+		}
+	}
+}
+
+func TestCompileNginxFormat(t *testing.T) {
+	lf, err := CompileNginxFormat(`$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" ${request_time}s $upstream_response_time`)
+	if err != nil {
+		t.Fatalf("CompileNginxFormat failed, got: %s", err)
+	}
+	line := `10.0.0.2 - - [07/Feb/2019:21:11:00 +0000] "GET /api/user HTTP/1.1" 200 1234 "-" "curl/7.64" 0.012s 0.010`
+	fields, ok := lf.Parse(line)
+	if !ok {
+		t.Fatalf("LogFormat.Parse failed to match: %s", line)
+	}
+	want := map[string]string{
+		"h":                      "10.0.0.2",
+		"u":                      "-",
+		"t":                      "07/Feb/2019:21:11:00 +0000",
+		"r":                      "GET /api/user HTTP/1.1",
+		"s":                      "200",
+		"b":                      "1234",
+		"{referer}i":             "-",
+		"{user-agent}i":          "curl/7.64",
+		"request_time":           "0.012",
+		"upstream_response_time": "0.010",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("LogFormat.Parse failed for %s. Want: %q. Got: %q", k, v, fields[k])
+		}
+	}
+	if _, err := CompileNginxFormat(`$remote_addr ${request_time`); err == nil {
+		t.Errorf("CompileNginxFormat failed. Want error for unclosed {")
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+// HitRing holds hit counts per second of event time for the last len(counts) seconds.
+// the slot of a second is second % len(counts), so advancing only clears the slots that fall out
+type HitRing struct {
+	counts []int
+	newest int // newest second in the ring. -1 until the first Advance
+	total  int
+}
+
+func NewHitRing(seconds int) *HitRing {
+	return &HitRing{make([]int, seconds), -1, 0}
+}
+
+// Advance moves the ring forward to second, dropping counts older than the window
+func (r *HitRing) Advance(second int) {
+	if r.newest < 0 {
+		r.newest = second
+		return
+	}
+	if second <= r.newest {
+		return
+	}
+	if second-r.newest >= len(r.counts) {
+		for i := range r.counts {
+			r.counts[i] = 0
+		}
+		r.total = 0
+	} else {
+		for s := r.newest + 1; s <= second; s++ {
+			slot := s % len(r.counts)
+			r.total -= r.counts[slot]
+			r.counts[slot] = 0
+		}
+	}
+	r.newest = second
+}
+
+// Add counts n hits at second. false if second already fell out of the window, or is not reached yet
+func (r *HitRing) Add(second int, n int) bool {
+	if r.newest < 0 || second > r.newest || second <= r.newest-len(r.counts) {
+		return false
+	}
+	r.counts[second%len(r.counts)] += n
+	r.total += n
+	return true
+}
+
+// Total is the number of hits in the window ending at the newest second
+func (r *HitRing) Total() int {
+	return r.total
+}
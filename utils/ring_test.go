@@ -0,0 +1,37 @@
+package utils
+
+import "testing"
+
+func TestHitRing(t *testing.T) {
+	r := NewHitRing(3)
+	r.Advance(100)
+	r.Add(100, 5)
+	r.Advance(101)
+	r.Add(101, 2)
+	r.Advance(102)
+	r.Add(102, 1)
+	if r.Total() != 8 {
+		t.Errorf("HitRing failed. Want: 8. Got: %d", r.Total())
+	}
+
+	// second 100 falls out
+	r.Advance(103)
+	if r.Total() != 3 {
+		t.Errorf("HitRing failed. Want: 3. Got: %d", r.Total())
+	}
+	if r.Add(100, 1) {
+		t.Errorf("HitRing failed. Want false adding a second out of the window")
+	}
+	if r.Add(104, 1) {
+		t.Errorf("HitRing failed. Want false adding a second ahead of the ring")
+	}
+	if !r.Add(101, 4) || r.Total() != 7 {
+		t.Errorf("HitRing failed. Want: 7. Got: %d", r.Total())
+	}
+
+	// gap longer than the window clears it
+	r.Advance(200)
+	if r.Total() != 0 {
+		t.Errorf("HitRing failed. Want: 0. Got: %d", r.Total())
+	}
+}
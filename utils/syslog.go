@@ -0,0 +1,112 @@
+package utils
+
+import "bufio"
+import "fmt"
+import "io"
+import "regexp"
+import "strconv"
+import str "strings"
+
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+var rfc5424Header = regexp.MustCompile(`^<\d{1,3}>\d{1,2} \S+ \S+ \S+ \S+ \S+ `)
+
+// <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: (timestamp and hostname may be missing)
+var rfc3164Header = regexp.MustCompile(`^<\d{1,3}>(?:[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d )?(?:\S+ )?[^\s:\[]+(?:\[\d+\])?: ?`)
+var rfc3164Timestamp = regexp.MustCompile(`^<\d{1,3}>(?:[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d )?`)
+
+// StripSyslog returns the message of an RFC 5424 or RFC 3164 syslog packet
+// false if msg carries no syslog <PRI> header at all
+func StripSyslog(msg string) (string, bool) {
+	if loc := rfc5424Header.FindStringIndex(msg); loc != nil {
+		return stripStructuredData(msg[loc[1]:]), true
+	}
+	if loc := rfc3164Header.FindStringIndex(msg); loc != nil {
+		return msg[loc[1]:], true
+	}
+	if loc := rfc3164Timestamp.FindStringIndex(msg); loc != nil {
+		return msg[loc[1]:], true
+	}
+	return msg, false
+}
+
+// skip RFC 5424 STRUCTURED-DATA. either "-" or [id param="value" ...] elements
+func stripStructuredData(rest string) string {
+	if str.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		for str.HasPrefix(rest, "[") {
+			i := 1
+			for ; i < len(rest) && rest[i] != ']'; i++ {
+				if rest[i] == '\\' {
+					i++
+				} else if rest[i] == '"' {
+					// quoted param value may contain ]
+					for i++; i < len(rest) && rest[i] != '"'; i++ {
+						if rest[i] == '\\' {
+							i++
+						}
+					}
+				}
+			}
+			if i >= len(rest) {
+				return ""
+			}
+			rest = rest[i+1:]
+		}
+	}
+	rest = str.TrimPrefix(rest, " ")
+	return str.TrimPrefix(rest, "\ufeff")
+}
+
+// MaxSyslogFrame is the longest message ReadSyslogFrame reads. longer frames are an error
+const MaxSyslogFrame = 64 << 10
+
+// ReadSyslogFrame reads one message from a syslog tcp stream (RFC 6587).
+// either octet counted ("LEN MSG") or terminated by a newline
+func ReadSyslogFrame(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if '0' <= b[0] && b[0] <= '9' {
+		var size []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || len(size) > len(strconv.Itoa(MaxSyslogFrame)) {
+				return "", fmt.Errorf("syslog: bad frame length %q", string(append(size, c)))
+			}
+			size = append(size, c)
+		}
+		n, _ := strconv.Atoi(string(size))
+		if n > MaxSyslogFrame {
+			return "", fmt.Errorf("syslog: frame length %d over %d", n, MaxSyslogFrame)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return str.TrimRight(string(buf), "\r\n"), nil
+	}
+
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(str.TrimRight(string(line), "\r\n")) > MaxSyslogFrame {
+			return "", fmt.Errorf("syslog: frame over %d", MaxSyslogFrame)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return str.TrimRight(string(line), "\r\n"), err
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import "bufio"
+import "fmt"
+import "io"
+import "strings"
+import "testing"
+
+const accessLine = `10.0.0.2 - - [07/Feb/2019:21:11:00 +0000] "GET /api/user HTTP/1.0" 200 1234`
+
+func TestStripSyslog(t *testing.T) {
+	packets := []string{
+		`<190>Feb  7 21:11:00 web1 apache2: ` + accessLine,
+		`<190>Feb  7 21:11:00 web1 apache2[4242]: ` + accessLine,
+		`<190>apache2: ` + accessLine,
+		`<190>1 2019-02-07T21:11:00.000Z web1 apache2 4242 access - ` + accessLine,
+		`<190>1 2019-02-07T21:11:00Z web1 apache2 - - [meta x="a]b"][origin ip="10.0.0.9"] ` + "\ufeff" + accessLine,
+	}
+	for _, packet := range packets {
+		msg, ok := StripSyslog(packet)
+		if !ok || msg != accessLine {
+			t.Errorf("StripSyslog failed for %q. Want: %q. Got: %q", packet, accessLine, msg)
+		}
+	}
+	if _, ok := StripSyslog(accessLine); ok {
+		t.Errorf("StripSyslog failed. Want false without <PRI>")
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	stream := "9 <13>hello10 <13>world\n<13>next\r\n<13>last"
+	r := bufio.NewReader(strings.NewReader(stream))
+	for _, want := range []string{"<13>hello", "<13>world", "<13>next", "<13>last"} {
+		msg, err := ReadSyslogFrame(r)
+		if err != nil || msg != want {
+			t.Errorf("ReadSyslogFrame failed. Want: %q. Got: %q (%v)", want, msg, err)
+		}
+	}
+	if _, err := ReadSyslogFrame(r); err != io.EOF {
+		t.Errorf("ReadSyslogFrame failed. Want io.EOF. Got: %v", err)
+	}
+}
+
+func TestReadSyslogFrameTooLong(t *testing.T) {
+	long := strings.Repeat("x", MaxSyslogFrame+1)
+	for _, stream := range []string{"4000000000 <13>hello", "99999999999999999999", fmt.Sprintf("%d %s", len(long), long), long + "\n"} {
+		r := bufio.NewReader(strings.NewReader(stream))
+		if msg, err := ReadSyslogFrame(r); err == nil {
+			t.Errorf("ReadSyslogFrame failed. Want error for a frame over %d. Got: %d bytes", MaxSyslogFrame, len(msg))
+		}
+	}
+
+	// the longest frame still goes through
+	fit := strings.Repeat("x", MaxSyslogFrame)
+	for _, stream := range []string{fmt.Sprintf("%d %s", len(fit), fit), fit + "\r\n"} {
+		r := bufio.NewReader(strings.NewReader(stream))
+		if msg, err := ReadSyslogFrame(r); err != nil || msg != fit {
+			t.Errorf("ReadSyslogFrame failed. Want: %d bytes. Got: %d bytes (%v)", len(fit), len(msg), err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import "sort"
 import "strconv"
 import "time"
 import "os"
+import "path/filepath"
 
 var sprint = fmt.Sprint
 var sprintf = fmt.Sprintf
@@ -24,6 +25,20 @@ func ParseEpochString(epoch string) (time.Time, error) {
     return tm, nil
 }
 
+// apache common log format timestamp. e.g. 10/Oct/2000:13:55:36 -0700
+func ParseCLFTime(datetime string) (time.Time, error) {
+	return time.Parse("02/Jan/2006:15:04:05 -0700", datetime)
+}
+
+// replay speed factor. e.g. 10x, 0.5x or 10
+func ParseSpeed(speed string) (float64, error) {
+	factor, err := strconv.ParseFloat(str.TrimSuffix(speed, "x"), 64)
+	if err != nil || factor <= 0 {
+		return 0, fmt.Errorf("bad speed: %s", speed)
+	}
+	return factor, nil
+}
+
 func AccuMap(mp map[string]int, accu map[string]int) map[string]int {
 	for k, v := range mp {
 		accu[k] += v
@@ -49,6 +64,35 @@ func ValidateFilePath(path string) {
 	}
 }
 
+// ExpandPaths splits a comma separated list of paths and glob patterns into the files they name
+func ExpandPaths(spec string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range str.Split(spec, ",") {
+		pattern = str.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad file pattern %s: %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("file not found: %s", pattern)
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input file given")
+	}
+	return paths, nil
+}
+
 func ParseSection(resource string) string {
 	split := str.Split(str.TrimPrefix(resource, "/"), "/")
 	first := split[0]
@@ -75,32 +119,122 @@ func SortMap(summary map[string]int) []kv {
     return arr
 }
 
-func BuildWindowStat(sb str.Builder, https map[string]int, statusCodes map[string]int, sections map[string]int, windowLen int, start int, end int) str.Builder {
-	sb = BuildTitle(sb)
-	sb = BuildThroughputSummary(sb, windowLen, start, end)
-	sb = BuildRequestMethodSummary(sb, https)
-	sb = BuildStatusCodeSummary(sb, statusCodes)
-	sb = BuildSectionSummary(sb, sections)
-	return sb
+func BuildWindowStat(sb *str.Builder, https map[string]int, statusCodes map[string]int, sections map[string]int, referers map[string]int, userAgents map[string]int, sources map[string]int, rejects map[string]int, lateDropped int, lateCorrected int, windowLen int, start int, end int) {
+	BuildTitle(sb, end - start)
+	BuildThroughputSummary(sb, windowLen, start, end)
+	BuildRequestMethodSummary(sb, https)
+	BuildStatusCodeSummary(sb, statusCodes)
+	BuildSectionSummary(sb, sections)
+	// only the combined log format carries referer and user-agent
+	if len(referers) > 0 {
+		BuildTopSummary(sb, "referer", referers, 3)
+	}
+	if len(userAgents) > 0 {
+		BuildTopSummary(sb, "user-agent", userAgents, 3)
+	}
+	// break totals down per input when several are merged
+	if len(sources) > 1 {
+		BuildTopSummary(sb, "source", sources, len(sources))
+	}
+	if len(rejects) > 0 {
+		BuildRejectSummary(sb, rejects)
+	}
+	BuildLateSummary(sb, lateDropped, lateCorrected)
+}
+
+// AlertSpan is one alert of a rule from firing to resolving
+type AlertSpan struct {
+	Name       string // of the rule
+	Severity   string
+	Metric     string
+	Begin      int
+	End        int     // -1 while still firing
+	Value      float64 // of the metric when it fired
+	EndOfInput bool    // resolved because the input ended, not because traffic dropped
+}
+
+// FormatMetric formats an alert rule metric value. hits are counts, rates per second, ratios a fraction of 1
+func FormatMetric(metric string, value float64) string {
+	if metric == "rate" {
+		return sprintf("%.2f/s", value)
+	} else if metric == "ratio" {
+		return sprintf("%.3f", value)
+	}
+	return sprintf("%d", int(value))
+}
+
+// RunStats accumulates the whole run for the report printed at the end of input
+type RunStats struct {
+	Start           int
+	End             int
+	Requests        int
+	Malformed       int
+	LateDropped     int
+	PeakSecond      int
+	PeakRequests    int
+	BusiestStart    int
+	BusiestEnd      int
+	BusiestRequests int
+	Methods         map[string]int
+	StatusCodes     map[string]int
+	Sections        map[string]int
+	Hosts           map[string]int
+	Alerts          []AlertSpan
+	Top             int // sections and hosts listed
+}
+
+func BuildRunStat(sb *str.Builder, run RunStats) {
+	BuildRunTitle(sb)
+	BuildThroughputSummary(sb, run.Requests, run.Start, run.End)
+	BuildRunTotals(sb, run)
+	BuildRequestMethodSummary(sb, run.Methods)
+	BuildStatusCodeSummary(sb, run.StatusCodes)
+	BuildTopSummary(sb, "section", run.Sections, run.Top)
+	BuildTopSummary(sb, "host", run.Hosts, run.Top)
+	BuildAlertSummary(sb, run.Alerts)
+}
+
+func BuildRunTitle(sb *str.Builder) {
+	sb.WriteString("[whole run stats]\n")
 }
 
-func BuildTitle(sb str.Builder) str.Builder {
-	sb.WriteString(fmt.Sprintf("[10 seconds window stats]"))
+func BuildRunTotals(sb *str.Builder, run RunStats) {
+	sb.WriteString(sprintf("[requests: %d, malformed: %d, late dropped: %d]\n", run.Requests, run.Malformed, run.LateDropped))
+	sb.WriteString(sprintf("[peak: %d req/s at %d, busiest window: start %d, end %d, requests %d]\n", run.PeakRequests, run.PeakSecond, run.BusiestStart, run.BusiestEnd, run.BusiestRequests))
+}
+
+// every alert with its duration. one still firing at the end of input has no end,
+// or is marked resolved by the end of input when checkRules closed it there
+func BuildAlertSummary(sb *str.Builder, alerts []AlertSpan) {
+	sb.WriteString(sprintf("[alerts: %d]\n", len(alerts)))
+	for _, alert := range alerts {
+		value := FormatMetric(alert.Metric, alert.Value)
+		if alert.End < 0 {
+			sb.WriteString(sprintf("%-14s [%s] begin: %d, end: -, %s: %s\n", alert.Name, alert.Severity, alert.Begin, alert.Metric, value))
+		} else if alert.EndOfInput {
+			sb.WriteString(sprintf("%-14s [%s] begin: %d, end: %d (end of input), duration: %ds, %s: %s\n", alert.Name, alert.Severity, alert.Begin, alert.End, alert.End-alert.Begin, alert.Metric, value))
+		} else {
+			sb.WriteString(sprintf("%-14s [%s] begin: %d, end: %d, duration: %ds, %s: %s\n", alert.Name, alert.Severity, alert.Begin, alert.End, alert.End-alert.Begin, alert.Metric, value))
+		}
+	}
 	sb.WriteString("\n")
-	return sb
 }
 
-func BuildThroughputSummary(sb str.Builder, accuThroughputs int, startSecond int, endSecond int) str.Builder {
+func BuildTitle(sb *str.Builder, windowSecond int) {
+	sb.WriteString(fmt.Sprintf("[%d seconds window stats]", windowSecond))
+	sb.WriteString("\n")
+}
+
+func BuildThroughputSummary(sb *str.Builder, accuThroughputs int, startSecond int, endSecond int) {
 	elapseSeconds := endSecond - startSecond
-	throughput := float64(accuThroughputs / elapseSeconds)
 	if elapseSeconds > 0 {
+		throughput := float64(accuThroughputs) / float64(elapseSeconds)
 		sb.WriteString(fmt.Sprintf("[start: %d, end %d, elapse: %d, throughputs: %.2f req/s]", startSecond, endSecond, elapseSeconds, throughput))
 		sb.WriteString("\n")
 	}
-	return sb
 }
 
-func BuildStatusCodeSummary(sb str.Builder, statusCodes map[string]int) str.Builder {
+func BuildStatusCodeSummary(sb *str.Builder, statusCodes map[string]int) {
 	all_status := map[string]int{"2XX": 0,"3XX": 0,"4XX": 0,"5XX": 0}
 	for k, v := range statusCodes {
 		all_status[sprint(k[:1], "XX")] += v 
@@ -110,10 +244,9 @@ func BuildStatusCodeSummary(sb str.Builder, statusCodes map[string]int) str.Buil
 		sb.WriteString(sprintf("%-8s%-6d", s, all_status[s]))
 	}
 	sb.WriteString("\n")
-	return sb
 }
 
-func BuildRequestMethodSummary(sb str.Builder, requestMethods map[string]int) str.Builder {
+func BuildRequestMethodSummary(sb *str.Builder, requestMethods map[string]int) {
 	all_methods := [8]string{"GET","HEAD","POST","PUT","DELETE","CONNECT","OPTIONS","TRACE"}
 	for _, v := range all_methods {
 		_, prs := requestMethods[v]
@@ -125,10 +258,9 @@ func BuildRequestMethodSummary(sb str.Builder, requestMethods map[string]int) st
 		sb.WriteString(sprintf("%-8s%-6d", v, requestMethods[v]))
 	}
 	sb.WriteString("\n")
-	return sb
 }
 
-func BuildSectionSummary(sb str.Builder, sections map[string]int) str.Builder {
+func BuildSectionSummary(sb *str.Builder, sections map[string]int) {
 	sortedSections := SortMap(sections)
 	sb.WriteString("\n")
 	sb.WriteString(sprintf("%-5s%-10s\n", "reqs", "section"))
@@ -140,7 +272,40 @@ func BuildSectionSummary(sb str.Builder, sections map[string]int) str.Builder {
 		sb.WriteString(sprintf("%-5d%-10s\n", obj.Value, obj.Key))
 	}
 	sb.WriteString("\n")
-	return sb
+}
+
+// out of order lines. dropped ones came after their window was reported
+func BuildLateSummary(sb *str.Builder, dropped int, corrected int) {
+	if dropped > 0 || corrected > 0 {
+		sb.WriteString(sprintf("[late lines: %d dropped, %d corrected]\n\n", dropped, corrected))
+	}
+}
+
+// malformed line counts by reason
+func BuildRejectSummary(sb *str.Builder, rejects map[string]int) {
+	total := 0
+	for _, v := range rejects {
+		total += v
+	}
+	sb.WriteString(sprintf("[malformed lines: %d]\n", total))
+	for _, obj := range SortMap(rejects) {
+		sb.WriteString(sprintf("%-5d%s\n", obj.Value, obj.Key))
+	}
+	sb.WriteString("\n")
+}
+
+// top n entries of counts, most requested first
+func BuildTopSummary(sb *str.Builder, column string, counts map[string]int, n int) {
+	sorted := SortMap(counts)
+	sb.WriteString(sprintf("%-5s%-10s\n", "reqs", column))
+	sb.WriteString("\n")
+	for idx, obj := range sorted {
+		if idx == n {
+			break
+		}
+		sb.WriteString(sprintf("%-5d%s\n", obj.Value, obj.Key))
+	}
+	sb.WriteString("\n")
 }
 
 // func main() {
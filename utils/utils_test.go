@@ -3,8 +3,16 @@ package utils
 import "fmt"
 import "time"
 import "testing"
+import "strings"
+import "io/ioutil"
+import "os"
+import "path/filepath"
 
 func TestParseSection(t * testing.T) {
+	sec1 := ParseSection("/api")
+	sec2 := ParseSection("/api/help")
+	sec3 := ParseSection("/")
+	sec4 := ParseSection("")
 	if sec1 != "api" {
 		t.Errorf("TestParseSection failed, Want: 'api'. Got: %s", sec1)
 	} else if sec2 != "help" {
@@ -29,6 +37,33 @@ func TestParseEpochString(t *testing.T) {
 	}
 }
 
+func TestParseCLFTime(t *testing.T) {
+	tm, err := ParseCLFTime("10/Oct/2000:13:55:36 -0700")
+	if err != nil {
+		t.Errorf("ParseCLFTime failed, got: %s", err)
+	}
+	if tm.Unix() != 971211336 {
+		t.Errorf("ParseCLFTime failed. Want %d. Got: %d", 971211336, tm.Unix())
+	}
+	if _, err := ParseCLFTime("1549573860"); err == nil {
+		t.Errorf("ParseCLFTime failed. Want error for epoch string")
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	for in, want := range map[string]float64{"10x": 10, "0.5x": 0.5, "2": 2} {
+		got, err := ParseSpeed(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSpeed failed. Want: %v. Got: %v, %v", want, got, err)
+		}
+	}
+	for _, in := range []string{"", "x", "fast", "0x", "-2x"} {
+		if _, err := ParseSpeed(in); err == nil {
+			t.Errorf("ParseSpeed failed. Want error for %q", in)
+		}
+	}
+}
+
 func TestAccuMap(t *testing.T) {
 	mp := map[string]int{"a": 10, "b": 20, "c": 30}
 	accu := map[string]int{"a": 1, "c":100}
@@ -38,3 +73,106 @@ func TestAccuMap(t *testing.T) {
 	}
 }
 
+
+func TestBuildTopSummary(t *testing.T) {
+	var sb strings.Builder
+	counts := map[string]int{"curl/7.64": 3, "Mozilla/5.0": 10, "Wget/1.20": 1}
+	BuildTopSummary(&sb, "user-agent", counts, 2)
+	want := "reqs user-agent\n\n10   Mozilla/5.0\n3    curl/7.64\n\n"
+	if sb.String() != want {
+		t.Errorf("BuildTopSummary failed. Want: %q. Got: %q", want, sb.String())
+	}
+}
+
+func TestBuildRejectSummary(t *testing.T) {
+	var sb strings.Builder
+	BuildRejectSummary(&sb, map[string]int{"bad epoch": 2, "wrong column count": 5})
+	want := "[malformed lines: 7]\n5    wrong column count\n2    bad epoch\n\n"
+	if sb.String() != want {
+		t.Errorf("BuildRejectSummary failed. Want: %q. Got: %q", want, sb.String())
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"web1", "web2"} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name, "access.log"), nil, 0644)
+	}
+	web1 := filepath.Join(dir, "web1", "access.log")
+	web2 := filepath.Join(dir, "web2", "access.log")
+
+	paths, err := ExpandPaths(filepath.Join(dir, "web*", "access.log") + "," + web1)
+	if err != nil {
+		t.Fatalf("ExpandPaths failed, got: %s", err)
+	}
+	if len(paths) != 2 || paths[0] != web1 || paths[1] != web2 {
+		t.Errorf("ExpandPaths failed. Want: [%s %s]. Got: %v", web1, web2, paths)
+	}
+	if _, err := ExpandPaths(filepath.Join(dir, "web3", "access.log")); err == nil {
+		t.Errorf("ExpandPaths failed. Want error for missing file")
+	}
+}
+
+func TestBuildLateSummary(t *testing.T) {
+	var sb strings.Builder
+	if BuildLateSummary(&sb, 0, 0); sb.String() != "" {
+		t.Errorf("BuildLateSummary failed. Want nothing without late lines. Got: %q", sb.String())
+	}
+	BuildLateSummary(&sb, 2, 7)
+	want := "[late lines: 2 dropped, 7 corrected]\n\n"
+	if sb.String() != want {
+		t.Errorf("BuildLateSummary failed. Want: %q. Got: %q", want, sb.String())
+	}
+}
+
+func TestBuildThroughputSummary(t *testing.T) {
+	var sb strings.Builder
+	BuildThroughputSummary(&sb, 45, 1549573800, 1549573860)
+	want := "[start: 1549573800, end 1549573860, elapse: 60, throughputs: 0.75 req/s]\n"
+	if sb.String() != want {
+		t.Errorf("BuildThroughputSummary failed. Want: %q. Got: %q", want, sb.String())
+	}
+}
+
+func TestBuildRunStat(t *testing.T) {
+	var sb strings.Builder
+	run := RunStats{
+		Start:           1549573859,
+		End:             1549574341,
+		Requests:        4830,
+		Malformed:       2,
+		LateDropped:     1,
+		PeakSecond:      1549573921,
+		PeakRequests:    20,
+		BusiestStart:    1549573920,
+		BusiestEnd:      1549573930,
+		BusiestRequests: 150,
+		Methods:         map[string]int{"GET": 4000, "POST": 830},
+		StatusCodes:     map[string]int{"200": 4500, "404": 300, "503": 30},
+		Sections:        map[string]int{"user": 3000, "report": 1830},
+		Hosts:           map[string]int{"10.0.0.1": 4830},
+		Alerts:          []AlertSpan{{"High traffic", "warning", "hits", 1549573921, 1549573985, 113, false}, {"POST rate", "critical", "rate", 1549574132, -1, 20.5, false}, {"api errors", "critical", "ratio", 1549574300, 1549574341, 0.125, true}},
+		Top:             1,
+	}
+	BuildRunStat(&sb, run)
+	want := "[whole run stats]\n" +
+		"[start: 1549573859, end 1549574341, elapse: 482, throughputs: 10.02 req/s]\n" +
+		"[requests: 4830, malformed: 2, late dropped: 1]\n" +
+		"[peak: 20 req/s at 1549573921, busiest window: start 1549573920, end 1549573930, requests 150]\n" +
+		"GET     4000  HEAD    0     POST    830   PUT     0     DELETE  0     CONNECT 0     OPTIONS 0     TRACE   0     \n" +
+		"2XX     4500  3XX     0     4XX     300   5XX     30    \n" +
+		"reqs section   \n\n3000 user\n\n" +
+		"reqs host      \n\n4830 10.0.0.1\n\n" +
+		"[alerts: 3]\n" +
+		"High traffic   [warning] begin: 1549573921, end: 1549573985, duration: 64s, hits: 113\n" +
+		"POST rate      [critical] begin: 1549574132, end: -, rate: 20.50/s\n" +
+		"api errors     [critical] begin: 1549574300, end: 1549574341 (end of input), duration: 41s, ratio: 0.125\n\n"
+	if sb.String() != want {
+		t.Errorf("BuildRunStat failed. Want: %q. Got: %q", want, sb.String())
+	}
+}